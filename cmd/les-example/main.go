@@ -24,6 +24,7 @@ import (
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/mattn/go-colorable"
 	"github.com/rjl493456442/les-simulator/simulator"
+	"github.com/rjl493456442/les-simulator/simulator/api"
 )
 
 var (
@@ -65,15 +66,24 @@ func main() {
 	if err != nil {
 		log.Crit("Failed to create les cluster", "error", err)
 	}
+	// The cluster API is created before starting the nodes for recording
+	// all the signer decisions.
+	clusterAPI := api.NewServer(cluster)
+
 	log.Info("starting cluster....")
-	cluster.StartNodes()
+	if err := cluster.StartNodes(); err != nil {
+		log.Crit("Failed to start les cluster", "error", err)
+	}
 
 	log.Info("Connecting nodes....")
 	cluster.Connect()
 
 	// start the HTTP API
 	log.Info("starting simulation server on 0.0.0.0:9999...")
-	if err := http.ListenAndServe(":9999", simulations.NewServer(cluster.Network())); err != nil {
+	mux := http.NewServeMux()
+	mux.Handle("/", simulations.NewServer(cluster.Network()))
+	mux.Handle("/cluster/", http.StripPrefix("/cluster", clusterAPI))
+	if err := http.ListenAndServe(":9999", mux); err != nil {
 		log.Crit("error starting simulation server", "err", err)
 	}
 }
@@ -0,0 +1,297 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+const consoleHelp = `Commands:
+  nodes                       list all the nodes
+  peers <node>                list the connected peers of the node
+  connect <node> <node>       connect two nodes
+  disconnect <node> <node>    disconnect two nodes
+  start <node>                start the node
+  stop <node>                 stop the node
+  mine <n> [server]           mine n blocks on the server, s0 by default
+  head <pattern>              show the head of the matched nodes, e.g. c* or s1
+  rpc <node> <method> [args]  call the RPC method, the args are parsed as JSON
+  help                        show this message
+  exit                        leave the console
+`
+
+// rpcTimeout is the maximum time for waiting a RPC call of the console.
+const rpcTimeout = time.Minute
+
+// console is the interactive shell operating on a running cluster through the
+// HTTP API of the simulation server.
+type console struct {
+	client *simulations.Client
+	out    io.Writer
+}
+
+func consoleCommand(args []string) {
+	flags := flag.NewFlagSet("console", flag.ExitOnError)
+	url := flags.String("url", "http://localhost:9999", "the URL of the simulation server")
+	flags.Parse(args)
+
+	c := &console{client: simulations.NewClient(*url), out: os.Stdout}
+	if _, err := c.client.GetNetwork(); err != nil {
+		fatalf("Failed to reach simulation server: %v\n", err)
+	}
+	c.run(os.Stdin)
+}
+
+// run reads the commands line by line until the input is closed or the exit
+// command is received.
+func (c *console) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(c.out, "> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(c.out)
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "exit" || fields[0] == "quit" {
+			return
+		}
+		if err := c.execute(fields[0], fields[1:]); err != nil {
+			fmt.Fprintf(c.out, "Error: %v\n", err)
+		}
+	}
+}
+
+func (c *console) execute(cmd string, args []string) error {
+	switch cmd {
+	case "help":
+		fmt.Fprint(c.out, consoleHelp)
+		return nil
+	case "nodes":
+		return c.nodes()
+	case "peers":
+		if len(args) != 1 {
+			return errors.New("usage: peers <node>")
+		}
+		return c.peers(args[0])
+	case "connect", "disconnect":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: %s <node> <node>", cmd)
+		}
+		if cmd == "connect" {
+			return c.client.ConnectNode(args[0], args[1])
+		}
+		return c.client.DisconnectNode(args[0], args[1])
+	case "start", "stop":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: %s <node>", cmd)
+		}
+		if cmd == "start" {
+			return c.client.StartNode(args[0])
+		}
+		return c.client.StopNode(args[0])
+	case "mine":
+		return c.mine(args)
+	case "head":
+		if len(args) != 1 {
+			return errors.New("usage: head <pattern>")
+		}
+		return c.head(args[0])
+	case "rpc":
+		if len(args) < 2 {
+			return errors.New("usage: rpc <node> <method> [args]")
+		}
+		return c.rpc(args[0], args[1], args[2:])
+	}
+	return fmt.Errorf("unknown command %q, try help", cmd)
+}
+
+// network returns the nodes of the network sorted by name and the mapping
+// from the node ID to the name.
+func (c *console) network() (*simulations.Network, map[enode.ID]string, error) {
+	network, err := c.client.GetNetwork()
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(network.Nodes, func(i, j int) bool {
+		return simulator.NodeLess(network.Nodes[i].Config.Name, network.Nodes[j].Config.Name)
+	})
+	names := make(map[enode.ID]string)
+	for _, node := range network.Nodes {
+		names[node.ID()] = node.Config.Name
+	}
+	return network, names, nil
+}
+
+func (c *console) nodes() error {
+	network, _, err := c.network()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tROLE\tUP\tID")
+	for _, node := range network.Nodes {
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", node.Config.Name, nodeRole(node.Config.Name), node.Up(), node.ID().TerminalString())
+	}
+	return tw.Flush()
+}
+
+func (c *console) peers(name string) error {
+	network, names, err := c.network()
+	if err != nil {
+		return err
+	}
+	var id enode.ID
+	for _, node := range network.Nodes {
+		if node.Config.Name == name {
+			id = node.ID()
+		}
+	}
+	if id == (enode.ID{}) {
+		return fmt.Errorf("unknown node %s", name)
+	}
+	var peers []string
+	for _, conn := range network.Conns {
+		if !conn.Up {
+			continue
+		}
+		switch id {
+		case conn.One:
+			peers = append(peers, names[conn.Other])
+		case conn.Other:
+			peers = append(peers, names[conn.One])
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return simulator.NodeLess(peers[i], peers[j]) })
+	fmt.Fprintf(c.out, "%d peer(s): %s\n", len(peers), strings.Join(peers, " "))
+	return nil
+}
+
+func (c *console) mine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: mine <n> [server]")
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return fmt.Errorf("invalid block number %q", args[0])
+	}
+	server := "s0"
+	if len(args) == 2 {
+		server = args[1]
+	}
+	if err := c.call(server, nil, "sim_mineBlocks", n); err != nil {
+		return err
+	}
+	return c.head(server)
+}
+
+func (c *console) head(pattern string) error {
+	network, _, err := c.network()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(c.out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tNUMBER\tHASH")
+	for _, node := range network.Nodes {
+		name := node.Config.Name
+		if ok, _ := path.Match(pattern, name); !ok || !node.Up() {
+			continue
+		}
+		var head struct {
+			Number string `json:"number"`
+			Hash   string `json:"hash"`
+		}
+		if err := c.call(name, &head, "eth_getBlockByNumber", "latest", false); err != nil {
+			fmt.Fprintf(tw, "%s\t%v\t\n", name, err)
+			continue
+		}
+		number, _ := strconv.ParseUint(strings.TrimPrefix(head.Number, "0x"), 16, 64)
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", name, number, head.Hash)
+	}
+	return tw.Flush()
+}
+
+func (c *console) rpc(name, method string, args []string) error {
+	var params []interface{}
+	for _, arg := range args {
+		var param interface{}
+		if err := json.Unmarshal([]byte(arg), &param); err != nil {
+			// Treat the argument which isn't valid JSON as a plain string,
+			// so that the addresses and hashes don't have to be quoted.
+			param = arg
+		}
+		params = append(params, param)
+	}
+	var result json.RawMessage
+	if err := c.call(name, &result, method, params...); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, string(out))
+	return nil
+}
+
+// call invokes the RPC method of the node via the simulation server.
+func (c *console) call(name string, result interface{}, method string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rpcTimeout)
+	defer cancel()
+
+	client, err := c.client.RPCClient(ctx, name)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	defer client.Close()
+
+	if err := client.CallContext(ctx, result, method, args...); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// nodeRole returns the role of the node by its name.
+func nodeRole(name string) string {
+	switch {
+	case strings.HasPrefix(name, "s"):
+		return "server"
+	case strings.HasPrefix(name, "c"):
+		return "client"
+	}
+	return "unknown"
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// newTestConsole creates the console operating on a connected cluster of two
+// servers and one client via the simulation server.
+func newTestConsole(t *testing.T) (*console, *bytes.Buffer, func()) {
+	t.Helper()
+
+	config := &simulator.ClusterConfig{
+		Adapter:      "sim",
+		ChainID:      1337,
+		Blocks:       4,
+		ManualMining: true,
+		ClientConfig: []*simulator.ClientServiceConfig{{LogVerbosity: log.LvlError}},
+	}
+	for i := 0; i < 2; i++ {
+		config.ServerConfig = append(config.ServerConfig, &simulator.ServerServiceConfig{
+			LightServ:        100,
+			LightPeers:       10,
+			LightNoSyncServe: true, // The servers are never synced without the peers
+			LogVerbosity:     log.LvlError,
+		})
+	}
+	cluster, err := simulator.NewCluster(config)
+	if err != nil {
+		t.Fatalf("Failed to create cluster: %v", err)
+	}
+	if err := cluster.StartNodes(); err != nil {
+		cluster.Close()
+		t.Fatalf("Failed to start cluster: %v", err)
+	}
+	if err := cluster.Connect(); err != nil {
+		cluster.Close()
+		t.Fatalf("Failed to connect cluster: %v", err)
+	}
+	server := httptest.NewServer(simulations.NewServer(cluster.Network()))
+	out := new(bytes.Buffer)
+	c := &console{client: simulations.NewClient(server.URL), out: out}
+	return c, out, func() {
+		server.Close()
+		cluster.Close()
+	}
+}
+
+func TestConsoleParsing(t *testing.T) {
+	c, out, stop := newTestConsole(t)
+	defer stop()
+
+	c.run(strings.NewReader("\nhelp\nfoo\npeers\nconnect s0\nmine\nmine x\nmine 0\nexit\nhelp\n"))
+	for _, want := range []string{
+		consoleHelp,
+		`Error: unknown command "foo", try help`,
+		"Error: usage: peers <node>",
+		"Error: usage: connect <node> <node>",
+		"Error: usage: mine <n> [server]",
+		`Error: invalid block number "x"`,
+		`Error: invalid block number "0"`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("Missing output %q in %q", want, out.String())
+		}
+	}
+	// The commands after exit are ignored
+	if n := strings.Count(out.String(), consoleHelp); n != 1 {
+		t.Fatalf("Unexpected help number, want 1, got %d", n)
+	}
+}
+
+func TestConsoleNodes(t *testing.T) {
+	c, out, stop := newTestConsole(t)
+	defer stop()
+
+	if err := c.execute("nodes", nil); err != nil {
+		t.Fatalf("Failed to list nodes: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := [][]string{{"NAME", "ROLE", "UP", "ID"}, {"s0", "server", "true"}, {"s1", "server", "true"}, {"c0", "client", "true"}}
+	if len(lines) != len(want) {
+		t.Fatalf("Unexpected node list %q", out.String())
+	}
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			t.Fatalf("Unexpected node line %q", line)
+		}
+		for j, field := range want[i] {
+			if fields[j] != field {
+				t.Fatalf("Unexpected node line %q, want %v", line, want[i])
+			}
+		}
+	}
+}
+
+func TestConsolePeers(t *testing.T) {
+	c, out, stop := newTestConsole(t)
+	defer stop()
+
+	// The connections are established asynchronously
+	expect := func(node, want string) {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			out.Reset()
+			if err := c.execute("peers", []string{node}); err != nil {
+				t.Fatalf("Failed to list peers of %s: %v", node, err)
+			}
+			if got := strings.TrimSpace(out.String()); got == want {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("Unexpected peers of %s, want %q, got %q", node, want, got)
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	expect("c0", "2 peer(s): s0 s1")
+	expect("s0", "2 peer(s): s1 c0")
+
+	if err := c.execute("disconnect", []string{"c0", "s1"}); err != nil {
+		t.Fatalf("Failed to disconnect nodes: %v", err)
+	}
+	expect("c0", "1 peer(s): s0")
+
+	if err := c.execute("peers", []string{"c1"}); err == nil || err.Error() != "unknown node c1" {
+		t.Fatalf("Unexpected error for unknown node: %v", err)
+	}
+}
+
+func TestConsoleMine(t *testing.T) {
+	c, out, stop := newTestConsole(t)
+	defer stop()
+
+	if err := c.execute("mine", []string{"2"}); err != nil {
+		t.Fatalf("Failed to mine blocks: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected head output %q", out.String())
+	}
+	if fields := strings.Fields(lines[1]); len(fields) != 3 || fields[0] != "s0" || fields[1] != "6" {
+		t.Fatalf("Unexpected head of s0 %q", lines[1])
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// les-sim is the toolbox for the LES simulations.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+const usage = `Usage: les-sim <command> [arguments]
+
+Commands:
+  trace summary <dir>       summarize the message trace of a cluster run
+  trace diff <dir1> <dir2>  find the first divergence between two traces
+  console [-url <url>]      operate on a running cluster interactively
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fatalf(usage)
+	}
+	switch os.Args[1] {
+	case "trace":
+		traceCommand(os.Args[2:])
+	case "console":
+		consoleCommand(os.Args[2:])
+	default:
+		fatalf(usage)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+	os.Exit(1)
+}
+
+func traceCommand(args []string) {
+	if len(args) < 1 {
+		fatalf(usage)
+	}
+	switch args[0] {
+	case "summary":
+		if len(args) != 2 {
+			fatalf(usage)
+		}
+		trace, err := simulator.LoadTrace(args[1])
+		if err != nil {
+			fatalf("Failed to load trace: %v\n", err)
+		}
+		printSummary(trace.Summarize())
+
+	case "diff":
+		flags := flag.NewFlagSet("diff", flag.ExitOnError)
+		sizes := flags.Bool("sizes", false, "compare the message sizes as well")
+		flags.Parse(args[1:])
+		if flags.NArg() != 2 {
+			fatalf(usage)
+		}
+		a, err := simulator.LoadTrace(flags.Arg(0))
+		if err != nil {
+			fatalf("Failed to load trace: %v\n", err)
+		}
+		b, err := simulator.LoadTrace(flags.Arg(1))
+		if err != nil {
+			fatalf("Failed to load trace: %v\n", err)
+		}
+		if d := simulator.DiffTraces(a, b, *sizes); d != nil {
+			fatalf("First divergence: %v\n", d)
+		}
+		fmt.Println("Traces are identical")
+
+	default:
+		fatalf(usage)
+	}
+}
+
+func printSummary(summary *simulator.TraceSummary) {
+	fmt.Printf("Trace from %v to %v (%v)\n\n", summary.Start, summary.End, summary.End.Sub(summary.Start))
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MESSAGE\tCOUNT\tBYTES")
+	for _, m := range summary.Messages {
+		fmt.Fprintf(tw, "%s\t%d\t%d\n", m.Name, m.Count, m.Bytes)
+	}
+	tw.Flush()
+
+	fmt.Println()
+	fmt.Fprintln(tw, "REQUEST\tCOUNT\tP50\tP90\tP99\tMAX")
+	for _, l := range summary.Latencies {
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%v\t%v\t%v\n", l.Name, l.Count, l.P50, l.P90, l.P99, l.Max)
+	}
+	tw.Flush()
+
+	fmt.Println()
+	fmt.Fprintln(tw, "FROM\tTO\tCOUNT\tBYTES\tBYTES/S")
+	for _, p := range summary.Peers {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f\n", p.From, p.To, p.Count, p.Bytes, p.Throughput)
+	}
+	tw.Flush()
+}
@@ -22,6 +22,7 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/keystore"
 	"github.com/ethereum/go-ethereum/common"
@@ -30,6 +31,7 @@ import (
 	"github.com/ethereum/go-ethereum/params"
 	"github.com/mattn/go-colorable"
 	"github.com/rjl493456442/les-simulator/simulator"
+	"github.com/rjl493456442/les-simulator/simulator/api"
 )
 
 var (
@@ -89,83 +91,27 @@ func main() {
 	if err != nil {
 		log.Crit("Failed to create les cluster", "error", err)
 	}
+	// The cluster API is created before starting the nodes for recording
+	// all the signer decisions.
+	clusterAPI := api.NewServer(cluster)
+
 	log.Info("starting cluster....")
-	cluster.StartNodes()
+	if err := cluster.StartNodes(); err != nil {
+		log.Crit("Failed to start les cluster", "error", err)
+	}
 
 	log.Info("Connecting nodes....")
 	cluster.Connect()
 
 	// start the HTTP API
 	log.Info("starting simulation server on 0.0.0.0:9999...")
-	if err := http.ListenAndServe(":9999", simulations.NewServer(cluster.Network())); err != nil {
+	mux := http.NewServeMux()
+	mux.Handle("/", simulations.NewServer(cluster.Network()))
+	mux.Handle("/cluster/", http.StripPrefix("/cluster", clusterAPI))
+	if err := http.ListenAndServe(":9999", mux); err != nil {
 		log.Crit("error starting simulation server", "err", err)
 	}
 }
 
-var signingRules = []byte(`
-// The rules for listing accounts
-function ApproveListing(req) {
-    return "Approve"
-}
-
-function big(str) {
-    if (str.slice(0, 2) == "0x") {
-        return new BigNumber(str.slice(2), 16)
-    }
-    return new BigNumber(str)
-}
-
-// Only 5e-2 ethers are allowed to spend in 1 week time.
-var window = 1000*3600*7;
-var limit = new BigNumber("5e16");
-
-function isLimitOk(transaction) {
-    var value = big(transaction.value)
-    var windowstart = new Date().getTime() - window;
-
-    var txs = [];
-    var stored = storage.get('txs');
-
-    if (stored != "") {
-        txs = JSON.parse(stored)
-    }
-    // First, remove all that have passed out of the time-window
-    var newtxs = txs.filter(function(tx){return tx.tstamp > windowstart});
-    // Secondly, aggregate the current sum
-    sum = new BigNumber(0)
-    sum = newtxs.reduce(function(agg, tx){ return big(tx.value).plus(agg)}, sum);
-    // Would we exceed weekly limit ?
-    return sum.plus(value).lt(limit)
-}
-
-// The rules for sigining transactions
-function ApproveTx(r) {
-    if (isLimitOk(r.transaction)) {
-        return "Approve"
-    }
-    return "Reject"
-}
-
-// OnApprovedTx(str) is called when a transaction has been approved and signed.
-function OnApprovedTx(resp) {
-    var value = big(resp.tx.value)
-    var txs = []
-    // Load stored transactions
-    var stored = storage.get('txs');
-    if (stored != "") {
-        txs = JSON.parse(stored)
-    }
-    // Add this to the storage
-    txs.push({tstamp: new Date().getTime(), value: value});
-    storage.put("txs", JSON.stringify(txs));
-}
-
-// The rules for sigining cheques
-function ApproveSignData(r) {
-    return "Approve"
-}
-
-// The rules for printing banner.
-function OnSignerStartup(i) {
-    return "Approve"
-}`)
+// Only 5e-2 ethers are allowed to spend in 1 week time, all the cheques are approved.
+var signingRules = simulator.NewSigningRules(simulator.SpendLimitRule(big.NewInt(5e16), 7*24*time.Hour))
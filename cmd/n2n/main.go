@@ -20,11 +20,17 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/mattn/go-colorable"
 	"github.com/rjl493456442/les-simulator/simulator"
+	"github.com/rjl493456442/les-simulator/simulator/api"
+	"github.com/rjl493456442/les-simulator/simulator/metrics"
 )
 
 var (
@@ -32,6 +38,14 @@ var (
 	servers  = flag.Int("servers", 10, "the number of les servers to be created")
 	clients  = flag.Int("clients", 10, "the number of les clients to be created")
 	routes   = flag.String("routes", "", "the network topology to be created, separated by comma(e.g. c1->s2,c2->s1,c3->*,*->s4)")
+	parallel = flag.Int("parallel", 4, "the maximum number of nodes to be started in parallel")
+
+	serverBinary = flag.String("server-binary", "", "the node binary for running servers, built with another go-ethereum version")
+	clientBinary = flag.String("client-binary", "", "the node binary for running clients, built with another go-ethereum version")
+
+	metricsOut = flag.String("metrics-out", "", "the file to export the collected metrics when exiting, in csv or json format by the extension")
+	traceDir   = flag.String("trace", "", "the directory to record the protocol messages of all nodes")
+	lesMetrics = flag.Bool("les-metrics", false, "enable the go-ethereum metrics in nodes, which adds their LES meters and timers to /metrics")
 )
 
 // main() starts a simulation network which contains nodes running a simple
@@ -53,6 +67,7 @@ func main() {
 		serverConfigs = append(serverConfigs, &simulator.ServerServiceConfig{
 			LightServ:    100,
 			LightPeers:   30,
+			Binary:       *serverBinary,
 			LogFile:      fmt.Sprintf("server-%02d.log", i),
 			LogVerbosity: log.LvlInfo,
 		})
@@ -61,6 +76,7 @@ func main() {
 		clientConfigs = append(clientConfigs, &simulator.ClientServiceConfig{
 			TrustedServers:  nil,
 			TrustedFraction: 0,
+			Binary:          *clientBinary,
 			LogFile:         fmt.Sprintf("client-%02d.log", i),
 			LogVerbosity:    log.LvlInfo,
 		})
@@ -78,21 +94,72 @@ func main() {
 		DeployPaymentContract: true,
 		DeployOracleContract:  true,
 		Conns:                 conns,
+		StartConcurrency:      *parallel,
+		MetricsEnabled:        *lesMetrics || *metricsOut != "",
+		TraceDir:              *traceDir,
 	})
 	if err != nil {
 		log.Crit("Failed to create les cluster", "error", err)
 	}
+	// The cluster API is created before starting the nodes for recording
+	// all the signer decisions.
+	clusterAPI := api.NewServer(cluster)
+
 	log.Info("starting cluster....")
-	cluster.StartNodes()
+	if err := cluster.StartNodes(); err != nil {
+		log.Crit("Failed to start les cluster", "error", err)
+	}
 
 	log.Info("Connecting nodes....")
 	if err := cluster.Connect(); err != nil {
 		log.Error("Connection failure", "error", err)
 	}
 
+	// The collector is always created for serving the prometheus metrics,
+	// but the time series are only recorded if the output is specified.
+	collector := metrics.NewCollector(cluster, nil)
+	if *metricsOut != "" {
+		collector.Start()
+	}
 	// start the HTTP API
-	log.Info("starting simulation server on 0.0.0.0:9999...")
-	if err := http.ListenAndServe(":9999", simulations.NewServer(cluster.Network())); err != nil {
-		log.Crit("error starting simulation server", "err", err)
+	mux := http.NewServeMux()
+	mux.Handle("/", simulations.NewServer(cluster.Network()))
+	mux.Handle("/cluster/", http.StripPrefix("/cluster", clusterAPI))
+	mux.Handle("/metrics", collector.Handler())
+	go func() {
+		log.Info("starting simulation server on 0.0.0.0:9999...")
+		if err := http.ListenAndServe(":9999", mux); err != nil {
+			log.Crit("error starting simulation server", "err", err)
+		}
+	}()
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	<-sigc
+
+	if *metricsOut != "" {
+		collector.Stop()
+		if err := exportMetrics(collector, *metricsOut); err != nil {
+			log.Error("Failed to export metrics", "error", err)
+		}
+		collector.WriteSummary(os.Stdout)
+	}
+	clusterAPI.Close()
+	if err := cluster.Close(); err != nil {
+		log.Error("Failed to close les cluster", "error", err)
+	}
+}
+
+// exportMetrics writes the collected metrics into the file, the format is
+// decided by the file extension.
+func exportMetrics(collector *metrics.Collector, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if strings.HasSuffix(path, ".json") {
+		return collector.ExportJSON(f)
 	}
+	return collector.ExportCSV(f)
 }
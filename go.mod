@@ -6,6 +6,7 @@ require (
 	github.com/davecgh/go-spew v1.1.1
 	github.com/ethereum/go-ethereum v1.9.11
 	github.com/mattn/go-colorable v0.1.6
+	github.com/robertkrimen/otto v0.0.0-20170205013659-6a77b7cbc37d
 )
 
 replace github.com/ethereum/go-ethereum => /Users/gary/gopath/src/github.com/ethereum/go-ethereum
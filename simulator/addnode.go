@@ -0,0 +1,185 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+)
+
+// addedLifecycle is the life cycle run by all the nodes added after the
+// cluster is created, see addedServices.
+const addedLifecycle = "les-added"
+
+// ErrAddUnsupported is returned if the nodes can't be added to the cluster.
+var ErrAddUnsupported = errors.New("adding nodes is not supported")
+
+// addedServices holds the life cycles of the nodes added after the cluster is
+// created, keyed by the node name.
+type addedServices struct {
+	lock     sync.Mutex
+	services map[string]adapters.LifecycleConstructor
+}
+
+func (s *addedServices) add(name string, constructor adapters.LifecycleConstructor) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.services[name] = constructor
+}
+
+// construct runs the life cycle of the added node with the name in the given
+// context, it implements adapters.LifecycleConstructor.
+func (s *addedServices) construct(ctx *adapters.ServiceContext, stack *node.Node) (node.Lifecycle, error) {
+	s.lock.Lock()
+	constructor := s.services[ctx.Config.Name]
+	s.lock.Unlock()
+
+	if constructor == nil {
+		return nil, fmt.Errorf("unknown added node %s", ctx.Config.Name)
+	}
+	return constructor(ctx, stack)
+}
+
+// AddServer creates a new server with the given config, starts it and connects
+// it to the running servers and clients. The new server has the next server
+// index, it shares the initial chain and doesn't mine. The nil config means
+// the config of the first server is used, without the per-node files.
+//
+// The nodes can only be added with the sim adapter, since the exec adapter
+// can't run the life cycles registered after the binary is started. Adding the
+// nodes with clef isn't supported either.
+func (cluster *Cluster) AddServer(config *ServerServiceConfig) (*LesServer, error) {
+	if err := cluster.checkAdd(); err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = new(ServerServiceConfig)
+		if len(cluster.config.ServerConfig) > 0 {
+			*config = *cluster.config.ServerConfig[0]
+			config.Chain, config.TraceFile, config.LogFile = nil, "", ""
+		}
+	}
+	if config.Chain != nil {
+		return nil, errors.New("chain override is not supported by added server")
+	}
+	cluster.lock.Lock()
+	index := len(cluster.servers)
+	if cluster.config.TraceDir != "" && config.TraceFile == "" {
+		traced := *config
+		traced.TraceFile = filepath.Join(cluster.config.TraceDir, serverName(index)+".trace")
+		config = &traced
+	}
+	cluster.added.add(serverName(index), NewLesServerService(config, cluster.bcfg, false))
+	n, err := cluster.addNode(serverNodeConfig(index, config, addedLifecycle))
+	if err != nil {
+		cluster.lock.Unlock()
+		return nil, &NodeError{Node: serverName(index), Err: err}
+	}
+	server := &LesServer{index: index, node: n}
+	cluster.servers = append(cluster.servers, server)
+	err = cluster.writeAddedTrace(server.Name())
+	cluster.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if err := cluster.JoinNode(server.Name()); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// AddClient creates a new client with the given config, starts it and connects
+// it to all the running servers. The new client has the next client index. The
+// nil config means the config of the first client is used, without the
+// per-node files. See AddServer for the restrictions.
+func (cluster *Cluster) AddClient(config *ClientServiceConfig) (*LesClient, error) {
+	if err := cluster.checkAdd(); err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = new(ClientServiceConfig)
+		if len(cluster.config.ClientConfig) > 0 {
+			*config = *cluster.config.ClientConfig[0]
+			config.TraceFile, config.LogFile = "", ""
+		}
+	}
+	cluster.lock.Lock()
+	index := len(cluster.clients)
+	if config.Behavior != nil && config.Behavior.RefusePayment {
+		cluster.lock.Unlock()
+		return nil, fmt.Errorf("%s: refusing payment requires clef", clientName(index))
+	}
+	if cluster.config.TraceDir != "" && config.TraceFile == "" {
+		traced := *config
+		traced.TraceFile = filepath.Join(cluster.config.TraceDir, clientName(index)+".trace")
+		config = &traced
+	}
+	var servers []*enode.Node
+	for _, server := range cluster.servers {
+		servers = append(servers, server.node.Config.Node())
+	}
+	cfg, err := clientNodeConfig(index, config, addedLifecycle, servers)
+	if err != nil {
+		cluster.lock.Unlock()
+		return nil, err
+	}
+	cluster.added.add(clientName(index), NewLesClientService(config, cluster.bcfg))
+	n, err := cluster.addNode(cfg)
+	if err != nil {
+		cluster.lock.Unlock()
+		return nil, &NodeError{Node: clientName(index), Err: err}
+	}
+	client := &LesClient{index: index, node: n}
+	cluster.clients = append(cluster.clients, client)
+	err = cluster.writeAddedTrace(client.Name())
+	cluster.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+	if err := cluster.JoinNode(client.Name()); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// checkAdd returns the error if the nodes can't be added to the cluster.
+func (cluster *Cluster) checkAdd() error {
+	if cluster.config.Adapter != "sim" {
+		return fmt.Errorf("%w by %s adapter", ErrAddUnsupported, cluster.config.Adapter)
+	}
+	if cluster.config.ClefEnabled {
+		return fmt.Errorf("%w with clef", ErrAddUnsupported)
+	}
+	return nil
+}
+
+// addNode creates the node with the given config and records its name. The
+// lock must be held.
+func (cluster *Cluster) addNode(cfg *adapters.NodeConfig) (*simulations.Node, error) {
+	if cluster.closed {
+		return nil, errors.New("cluster closed")
+	}
+	n, err := cluster.network.NewNodeWithConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cluster.names[n.ID()] = cfg.Name
+	return n, nil
+}
+
+// writeAddedTrace updates the trace nodes with the added node if the tracing
+// is enabled. The lock must be held.
+func (cluster *Cluster) writeAddedTrace(name string) error {
+	if cluster.config.TraceDir == "" {
+		return nil
+	}
+	return cluster.writeTraceNodes([]string{name})
+}
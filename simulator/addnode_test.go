@@ -0,0 +1,77 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestAddNodeUnsupported(t *testing.T) {
+	for _, config := range []*ClusterConfig{{Adapter: "exec"}, {Adapter: "sim", ClefEnabled: true}} {
+		cluster := &Cluster{config: config}
+		if _, err := cluster.AddServer(nil); !errors.Is(err, ErrAddUnsupported) {
+			t.Fatalf("Unexpected error of adding server, want %v, got %v", ErrAddUnsupported, err)
+		}
+		if _, err := cluster.AddClient(nil); !errors.Is(err, ErrAddUnsupported) {
+			t.Fatalf("Unexpected error of adding client, want %v, got %v", ErrAddUnsupported, err)
+		}
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	cluster := newTestCluster(t, 1, 1, func(config *ClusterConfig) {
+		config.Conns = []*Conn{{From: 0, To: 0}}
+		config.ServerConfig[0].LightNoSyncServe = true // The servers are never synced without the peers
+	})
+	defer cluster.Close()
+
+	if _, err := cluster.AddServer(&ServerServiceConfig{Chain: new(ChainOverride)}); err == nil {
+		t.Fatalf("Chain override of added server should be rejected")
+	}
+	// The config of the first server is used by default
+	server, err := cluster.AddServer(nil)
+	if err != nil {
+		t.Fatalf("Failed to add server: %v", err)
+	}
+	client, err := cluster.AddClient(&ClientServiceConfig{PinnedServers: []int{1}})
+	if err != nil {
+		t.Fatalf("Failed to add client: %v", err)
+	}
+	if server.Name() != "s1" || client.Name() != "c1" || !server.Node().Up() || !client.Node().Up() {
+		t.Fatalf("Unexpected added nodes %s, %s", server.Name(), client.Name())
+	}
+	if node := cluster.NodeByName("c1"); node != client.Node() {
+		t.Fatalf("Added client is not found by name")
+	}
+	// The added client is connected to all the servers beyond the topology
+	var links []string
+	for _, link := range cluster.Topology() {
+		links = append(links, link.One+"-"+link.Other)
+	}
+	if want := "[c0-s0 c1-s0 c1-s1 s0-s1]"; fmt.Sprint(links) != want {
+		t.Fatalf("Unexpected topology, want %s, got %v", want, links)
+	}
+	// Wait until the added client is served by both servers, the node can't
+	// be closed safely during the handshake
+	rpc, err := client.Node().Client()
+	if err != nil {
+		t.Fatalf("Failed to connect %s: %v", client.Name(), err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var peers hexutil.Uint
+		if err := rpc.Call(&peers, "net_peerCount"); err != nil {
+			t.Fatalf("Failed to retrieve peer count: %v", err)
+		}
+		if peers == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected peer count of %s, want 2, got %d", client.Name(), peers)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,362 @@
+// Package api provides the HTTP API of the LES cluster, which complements the
+// generic simulation API with the LES roles, signers and contracts.
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// headTimeout is the maximum time for retrieving the head of a node.
+const headTimeout = 5 * time.Second
+
+// NodeInfo is the summary of the server or client in the cluster.
+type NodeInfo struct {
+	Index  int    `json:"index"`
+	Name   string `json:"name"`
+	ID     string `json:"id"`
+	Up     bool   `json:"up"`
+	Signer bool   `json:"signer"`          // Whether the node signs via clef
+	Miner  bool   `json:"miner,omitempty"` // Whether the server mines
+}
+
+// Nodes is the list of the servers and clients.
+type Nodes struct {
+	Servers []*NodeInfo `json:"servers"`
+	Clients []*NodeInfo `json:"clients"`
+}
+
+// Head is the head block of the node.
+type Head struct {
+	Node   string         `json:"node"`
+	Number hexutil.Uint64 `json:"number"`
+	Hash   common.Hash    `json:"hash"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// Contracts is the addresses of the system contracts, the empty address means
+// the contract isn't deployed.
+type Contracts struct {
+	Oracle  common.Address `json:"oracle"`
+	Lottery common.Address `json:"lottery"`
+}
+
+// Server is the HTTP handler of the cluster API. The endpoints are:
+//
+//	GET  /nodes                  list the servers and clients with indexes
+//	GET  /topology               list the configured connections and states
+//	GET  /heads                  show the heads of the running nodes
+//	GET  /signers[?node=<name>]  list the signer decisions
+//	GET  /contracts              show the addresses of the system contracts
+//	POST /mine?blocks=<n>        mine the blocks on the first miner
+//	POST /partition              split the network, the body is the groups of
+//	                             node names, e.g. [["s0","c0"],["s1","c1"]]
+//	POST /heal                   restore the connections of the topology
+//	POST /join?node=<name>       start the node and connect it to the peers
+//	POST /add?role=<role>        add a new server or client, the body is the
+//	                             optional service config in JSON, by default
+//	                             the config of the first node of the role is
+//	                             used. It's only supported by the sim adapter
+type Server struct {
+	cluster *simulator.Cluster
+	mux     *http.ServeMux
+	audit   *auditLog
+
+	sub event.Subscription
+	wg  sync.WaitGroup
+}
+
+// NewServer creates the API server of the cluster. The signer decisions are
+// recorded since the server is created, so it should be created before the
+// cluster is started.
+func NewServer(cluster *simulator.Cluster) *Server {
+	s := &Server{
+		cluster: cluster,
+		mux:     http.NewServeMux(),
+		audit:   newAuditLog(maxAuditEntries),
+	}
+	events, sub := cluster.Subscribe(&simulator.EventFilter{
+		Types: []simulator.EventType{simulator.EventSignerApproved, simulator.EventSignerRejected},
+	})
+	s.sub = sub
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for ev := range events {
+			s.audit.add(ev)
+		}
+	}()
+	s.mux.HandleFunc("/nodes", s.get(s.nodes))
+	s.mux.HandleFunc("/topology", s.get(s.topology))
+	s.mux.HandleFunc("/heads", s.get(s.heads))
+	s.mux.HandleFunc("/signers", s.get(s.signers))
+	s.mux.HandleFunc("/contracts", s.get(s.contracts))
+	s.mux.HandleFunc("/mine", s.post(s.mine))
+	s.mux.HandleFunc("/partition", s.post(s.partition))
+	s.mux.HandleFunc("/heal", s.post(s.heal))
+	s.mux.HandleFunc("/join", s.post(s.join))
+	s.mux.HandleFunc("/add", s.post(s.add))
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close stops recording the signer decisions.
+func (s *Server) Close() {
+	s.sub.Unsubscribe()
+	s.wg.Wait()
+}
+
+// handler is the endpoint which returns either the result to be encoded in
+// JSON or the error.
+type handler func(r *http.Request) (interface{}, error)
+
+// httpError is the error with the HTTP status code.
+type httpError struct {
+	code int
+	err  error
+}
+
+func (e *httpError) Error() string { return e.err.Error() }
+
+func badRequest(format string, args ...interface{}) error {
+	return &httpError{code: http.StatusBadRequest, err: fmt.Errorf(format, args...)}
+}
+
+func (s *Server) get(h handler) http.HandlerFunc {
+	return s.method(http.MethodGet, h)
+}
+
+func (s *Server) post(h handler) http.HandlerFunc {
+	return s.method(http.MethodPost, h)
+}
+
+func (s *Server) method(method string, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		result, err := h(r)
+		if err != nil {
+			code := http.StatusInternalServerError
+			var herr *httpError
+			if errors.As(err, &herr) {
+				code = herr.code
+			} else if errors.Is(err, simulator.ErrAddUnsupported) {
+				code = http.StatusNotImplemented
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+		if result == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+func (s *Server) nodes(r *http.Request) (interface{}, error) {
+	nodes := &Nodes{Servers: []*NodeInfo{}, Clients: []*NodeInfo{}}
+	for _, server := range s.cluster.Servers() {
+		nodes.Servers = append(nodes.Servers, s.serverInfo(server))
+	}
+	for _, client := range s.cluster.Clients() {
+		nodes.Clients = append(nodes.Clients, clientInfo(client))
+	}
+	return nodes, nil
+}
+
+func (s *Server) serverInfo(server *simulator.LesServer) *NodeInfo {
+	var miner bool
+	for _, index := range s.cluster.Miners() {
+		miner = miner || index == server.Index()
+	}
+	return &NodeInfo{
+		Index:  server.Index(),
+		Name:   server.Name(),
+		ID:     server.Node().ID().String(),
+		Up:     server.Node().Up(),
+		Signer: server.Signer() != nil,
+		Miner:  miner,
+	}
+}
+
+func clientInfo(client *simulator.LesClient) *NodeInfo {
+	return &NodeInfo{
+		Index:  client.Index(),
+		Name:   client.Name(),
+		ID:     client.Node().ID().String(),
+		Up:     client.Node().Up(),
+		Signer: client.Signer() != nil,
+	}
+}
+
+func (s *Server) topology(r *http.Request) (interface{}, error) {
+	links := s.cluster.Topology()
+	if links == nil {
+		links = []*simulator.Link{}
+	}
+	return links, nil
+}
+
+func (s *Server) heads(r *http.Request) (interface{}, error) {
+	var nodes []*simulations.Node
+	var names []string
+	for _, server := range s.cluster.Servers() {
+		nodes, names = append(nodes, server.Node()), append(names, server.Name())
+	}
+	for _, client := range s.cluster.Clients() {
+		nodes, names = append(nodes, client.Node()), append(names, client.Name())
+	}
+	// Retrieve the heads concurrently, the slow nodes shouldn't block the
+	// others.
+	var (
+		heads = make([]*Head, len(nodes))
+		wg    sync.WaitGroup
+	)
+	for i, node := range nodes {
+		if !node.Up() {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, node *simulations.Node) {
+			defer wg.Done()
+			heads[i] = nodeHead(r.Context(), names[i], node)
+		}(i, node)
+	}
+	wg.Wait()
+
+	result := []*Head{}
+	for _, head := range heads {
+		if head != nil {
+			result = append(result, head)
+		}
+	}
+	return result, nil
+}
+
+// nodeHead retrieves the head block of the node via RPC.
+func nodeHead(ctx context.Context, name string, node *simulations.Node) *Head {
+	head := &Head{Node: name}
+	client, err := node.Client()
+	if err != nil {
+		head.Error = err.Error()
+		return head
+	}
+	ctx, cancel := context.WithTimeout(ctx, headTimeout)
+	defer cancel()
+
+	if err := client.CallContext(ctx, head, "eth_getBlockByNumber", "latest", false); err != nil {
+		head.Error = err.Error()
+	}
+	return head
+}
+
+func (s *Server) signers(r *http.Request) (interface{}, error) {
+	return s.audit.list(r.URL.Query().Get("node")), nil
+}
+
+func (s *Server) contracts(r *http.Request) (interface{}, error) {
+	return &Contracts{
+		Oracle:  s.cluster.OracleAddress(),
+		Lottery: s.cluster.LotteryAddress(),
+	}, nil
+}
+
+func (s *Server) mine(r *http.Request) (interface{}, error) {
+	blocks := 1
+	if value := r.URL.Query().Get("blocks"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return nil, badRequest("invalid block number %q", value)
+		}
+		blocks = n
+	}
+	return nil, s.cluster.MineBlocks(blocks)
+}
+
+func (s *Server) partition(r *http.Request) (interface{}, error) {
+	var groups [][]string
+	if err := json.NewDecoder(r.Body).Decode(&groups); err != nil {
+		return nil, badRequest("invalid partition groups: %v", err)
+	}
+	if len(groups) < 2 {
+		return nil, badRequest("at least two partition groups are required")
+	}
+	return nil, s.cluster.Partition(groups)
+}
+
+func (s *Server) heal(r *http.Request) (interface{}, error) {
+	return nil, s.cluster.Heal()
+}
+
+func (s *Server) join(r *http.Request) (interface{}, error) {
+	name := r.URL.Query().Get("node")
+	if s.cluster.NodeByName(name) == nil {
+		return nil, badRequest("unknown node %q", name)
+	}
+	return nil, s.cluster.JoinNode(name)
+}
+
+func (s *Server) add(r *http.Request) (interface{}, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, badRequest("invalid node config: %v", err)
+	}
+	// decode decodes the optional service config, nil is returned as is so
+	// that the default config is used.
+	decode := func(config interface{}) error {
+		if len(bytes.TrimSpace(body)) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(body, config); err != nil {
+			return badRequest("invalid node config: %v", err)
+		}
+		return nil
+	}
+	switch role := r.URL.Query().Get("role"); role {
+	case "server":
+		var config *simulator.ServerServiceConfig
+		if err := decode(&config); err != nil {
+			return nil, err
+		}
+		server, err := s.cluster.AddServer(config)
+		if err != nil {
+			return nil, err
+		}
+		return s.serverInfo(server), nil
+	case "client":
+		var config *simulator.ClientServiceConfig
+		if err := decode(&config); err != nil {
+			return nil, err
+		}
+		client, err := s.cluster.AddClient(config)
+		if err != nil {
+			return nil, err
+		}
+		return clientInfo(client), nil
+	default:
+		return nil, badRequest("invalid node role %q", role)
+	}
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// newTestServer creates the API server of a connected cluster with two servers
+// and one client.
+func newTestServer(t *testing.T) (*httptest.Server, func()) {
+	t.Helper()
+
+	config := &simulator.ClusterConfig{
+		Adapter:      "sim",
+		ChainID:      1337,
+		Blocks:       4,
+		ManualMining: true,
+		ClientConfig: []*simulator.ClientServiceConfig{{LogVerbosity: log.LvlError}},
+	}
+	for i := 0; i < 2; i++ {
+		config.ServerConfig = append(config.ServerConfig, &simulator.ServerServiceConfig{
+			LightServ:        100,
+			LightPeers:       10,
+			LightNoSyncServe: true, // The servers are never synced without the peers
+			LogVerbosity:     log.LvlError,
+		})
+	}
+	cluster, err := simulator.NewCluster(config)
+	if err != nil {
+		t.Fatalf("Failed to create cluster: %v", err)
+	}
+	api := NewServer(cluster)
+	if err := cluster.StartNodes(); err != nil {
+		api.Close()
+		cluster.Close()
+		t.Fatalf("Failed to start cluster: %v", err)
+	}
+	if err := cluster.Connect(); err != nil {
+		api.Close()
+		cluster.Close()
+		t.Fatalf("Failed to connect cluster: %v", err)
+	}
+	server := httptest.NewServer(api)
+	return server, func() {
+		server.Close()
+		api.Close()
+		cluster.Close()
+	}
+}
+
+// request sends the request to the API server and decodes the JSON result if
+// it's not nil. The status code is returned.
+func request(t *testing.T, server *httptest.Server, method, path, body string, result interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request %s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if result != nil && res.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+			t.Fatalf("Failed to decode result of %s %s: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+// waitTopology waits until the state of the links is the same as the given
+// one, which maps the link "one-other" to whether it's up.
+func waitTopology(t *testing.T, server *httptest.Server, want map[string]bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var links []*simulator.Link
+		if code := request(t, server, http.MethodGet, "/topology", "", &links); code != http.StatusOK {
+			t.Fatalf("Failed to retrieve topology, status %d", code)
+		}
+		got := make(map[string]bool)
+		for _, link := range links {
+			got[link.One+"-"+link.Other] = link.Up
+		}
+		if fmt.Sprint(got) == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Unexpected topology, want %v, got %v", want, got)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	var nodes Nodes
+	if code := request(t, server, http.MethodGet, "/nodes", "", &nodes); code != http.StatusOK {
+		t.Fatalf("Failed to list nodes, status %d", code)
+	}
+	if len(nodes.Servers) != 2 || len(nodes.Clients) != 1 {
+		t.Fatalf("Unexpected node number, servers %d, clients %d", len(nodes.Servers), len(nodes.Clients))
+	}
+	for i, info := range nodes.Servers {
+		if info.Index != i || info.Name != fmt.Sprintf("s%d", i) || !info.Up || info.Miner != (i == 0) {
+			t.Fatalf("Unexpected server %+v", info)
+		}
+	}
+	if info := nodes.Clients[0]; info.Index != 0 || info.Name != "c0" || !info.Up || info.Miner {
+		t.Fatalf("Unexpected client %+v", info)
+	}
+}
+
+func TestMine(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	if code := request(t, server, http.MethodPost, "/mine?blocks=2", "", nil); code != http.StatusOK {
+		t.Fatalf("Failed to mine blocks, status %d", code)
+	}
+	var heads []*Head
+	if code := request(t, server, http.MethodGet, "/heads", "", &heads); code != http.StatusOK {
+		t.Fatalf("Failed to retrieve heads, status %d", code)
+	}
+	if len(heads) != 3 || heads[0].Node != "s0" || heads[0].Number != 6 {
+		t.Fatalf("Unexpected heads %+v", heads)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	waitTopology(t, server, map[string]bool{"c0-s0": true, "c0-s1": true, "s0-s1": true})
+
+	if code := request(t, server, http.MethodPost, "/partition", `[["s0","c0"],["s1"]]`, nil); code != http.StatusOK {
+		t.Fatalf("Failed to partition network, status %d", code)
+	}
+	waitTopology(t, server, map[string]bool{"c0-s0": true, "c0-s1": false, "s0-s1": false})
+
+	// The reconnection isn't awaited, the dialer doesn't redial the recently
+	// dialed nodes within half a minute.
+	if code := request(t, server, http.MethodPost, "/heal", "", nil); code != http.StatusOK {
+		t.Fatalf("Failed to heal network, status %d", code)
+	}
+}
+
+func TestAddNode(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	var info NodeInfo
+	if code := request(t, server, http.MethodPost, "/add?role=client", "", &info); code != http.StatusOK {
+		t.Fatalf("Failed to add client, status %d", code)
+	}
+	if info.Index != 1 || info.Name != "c1" || !info.Up {
+		t.Fatalf("Unexpected added client %+v", info)
+	}
+	config := `{"LightServ":100,"LightPeers":10,"LightNoSyncServe":true,"LogVerbosity":1}`
+	if code := request(t, server, http.MethodPost, "/add?role=server", config, &info); code != http.StatusOK {
+		t.Fatalf("Failed to add server, status %d", code)
+	}
+	if info.Index != 2 || info.Name != "s2" || !info.Up || info.Miner {
+		t.Fatalf("Unexpected added server %+v", info)
+	}
+	var nodes Nodes
+	if code := request(t, server, http.MethodGet, "/nodes", "", &nodes); code != http.StatusOK {
+		t.Fatalf("Failed to list nodes, status %d", code)
+	}
+	if len(nodes.Servers) != 3 || len(nodes.Clients) != 2 {
+		t.Fatalf("Unexpected node number, servers %d, clients %d", len(nodes.Servers), len(nodes.Clients))
+	}
+	// The added nodes are connected according to the default topology
+	waitTopology(t, server, map[string]bool{
+		"c0-s0": true, "c0-s1": true, "c0-s2": true,
+		"c1-s0": true, "c1-s1": true, "c1-s2": true,
+		"s0-s1": true, "s0-s2": true, "s1-s2": true,
+	})
+}
+
+func TestRequestErrors(t *testing.T) {
+	server, stop := newTestServer(t)
+	defer stop()
+
+	for _, test := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{http.MethodPost, "/nodes", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/mine", "", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/add", "", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/mine?blocks=x", "", http.StatusBadRequest},
+		{http.MethodPost, "/mine?blocks=0", "", http.StatusBadRequest},
+		{http.MethodPost, "/partition", "invalid", http.StatusBadRequest},
+		{http.MethodPost, "/partition", `[["s0","s1"]]`, http.StatusBadRequest},
+		{http.MethodPost, "/join?node=c9", "", http.StatusBadRequest},
+		{http.MethodPost, "/add?role=miner", "", http.StatusBadRequest},
+		{http.MethodPost, "/add?role=client", "invalid", http.StatusBadRequest},
+	} {
+		if code := request(t, server, test.method, test.path, test.body, nil); code != test.code {
+			t.Fatalf("Unexpected status of %s %s, want %d, got %d", test.method, test.path, test.code, code)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// maxAuditEntries is the maximum number of the signer decisions kept in the
+// audit log, the oldest ones are evicted first.
+const maxAuditEntries = 4096
+
+// AuditEntry is the signing decision made by the signer of the node.
+type AuditEntry struct {
+	Time     time.Time      `json:"time"`
+	Node     string         `json:"node"`
+	Request  string         `json:"request"`
+	Account  common.Address `json:"account"`
+	Approved bool           `json:"approved"`
+}
+
+// auditLog records the recent signer decisions of all the nodes.
+type auditLog struct {
+	lock    sync.Mutex
+	entries []*AuditEntry
+	limit   int
+}
+
+func newAuditLog(limit int) *auditLog {
+	return &auditLog{limit: limit}
+}
+
+// add records the signer event.
+func (l *auditLog) add(ev *simulator.Event) {
+	if ev.Decision == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.entries = append(l.entries, &AuditEntry{
+		Time:     ev.Decision.Time,
+		Node:     ev.Node,
+		Request:  ev.Decision.Request,
+		Account:  ev.Decision.Account,
+		Approved: ev.Decision.Approved,
+	})
+	if len(l.entries) > l.limit {
+		l.entries = append([]*AuditEntry(nil), l.entries[len(l.entries)-l.limit:]...)
+	}
+}
+
+// list returns the recorded decisions of the given node in chronological
+// order, the empty node name matches all.
+func (l *auditLog) list(node string) []*AuditEntry {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	entries := []*AuditEntry{}
+	for _, entry := range l.entries {
+		if node == "" || entry.Node == node {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+func TestAuditLog(t *testing.T) {
+	log := newAuditLog(3)
+	for i, node := range []string{"s0", "c0", "s0", "c1", "s0"} {
+		log.add(&simulator.Event{
+			Type:     simulator.EventSignerApproved,
+			Node:     node,
+			Decision: &simulator.SignerDecision{Time: time.Unix(int64(i), 0), Request: "data", Approved: i%2 == 0},
+		})
+	}
+	// The events without decision are ignored
+	log.add(&simulator.Event{Type: simulator.EventNodeUp, Node: "s0"})
+
+	all := log.list("")
+	if len(all) != 3 {
+		t.Fatalf("Unexpected entry number, want 3, got %d", len(all))
+	}
+	for i, want := range []string{"s0", "c1", "s0"} {
+		if all[i].Node != want || all[i].Time.Unix() != int64(i+2) {
+			t.Fatalf("Unexpected entry %d, want %s at %d, got %s at %d", i, want, i+2, all[i].Node, all[i].Time.Unix())
+		}
+	}
+	if entries := log.list("s0"); len(entries) != 2 || !entries[0].Approved || !entries[1].Approved {
+		t.Fatalf("Unexpected entries of s0: %v", entries)
+	}
+	if entries := log.list("c9"); entries == nil || len(entries) != 0 {
+		t.Fatalf("Unexpected entries of c9: %v", entries)
+	}
+}
@@ -0,0 +1,267 @@
+// Package assert contains the helpers for checking the data served by the light
+// clients against the full node data of the servers. All the checks are done
+// via per-node RPC.
+package assert
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	oracle "github.com/ethereum/go-ethereum/contracts/checkpointoracle/contract"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// Timeout is the maximum time for a single RPC call, the on-demand retrieval
+// of the light client may take a while.
+var Timeout = 30 * time.Second
+
+// pair is the RPC connections of the client and server to be compared.
+type pair struct {
+	client    *rpc.Client
+	server    *rpc.Client
+	clientEth *ethclient.Client
+	serverEth *ethclient.Client
+	name      string // Human readable name of the pair, e.g. c0/s1
+}
+
+func dial(cluster *simulator.Cluster, client, server int) (*pair, error) {
+	var (
+		clients = cluster.Clients()
+		servers = cluster.Servers()
+	)
+	if client < 0 || client >= len(clients) {
+		return nil, fmt.Errorf("invalid client index %d", client)
+	}
+	if server < 0 || server >= len(servers) {
+		return nil, fmt.Errorf("invalid server index %d", server)
+	}
+	c, err := clients[client].Node().Client()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", clients[client].Name(), err)
+	}
+	s, err := servers[server].Node().Client()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", servers[server].Name(), err)
+	}
+	return &pair{
+		client:    c,
+		server:    s,
+		clientEth: ethclient.NewClient(c),
+		serverEth: ethclient.NewClient(s),
+		name:      fmt.Sprintf("%s/%s", clients[client].Name(), servers[server].Name()),
+	}, nil
+}
+
+// HeaderChain checks the header chain of the client equals the canonical chain
+// of the server from genesis up to the given number.
+func HeaderChain(cluster *simulator.Cluster, client, server int, number uint64) error {
+	p, err := dial(cluster, client, server)
+	if err != nil {
+		return err
+	}
+	for n := uint64(0); n <= number; n++ {
+		if err := compareHeader(p, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareHeader checks the client header of the given number equals the
+// server's, each header is retrieved with its own timeout.
+func compareHeader(p *pair, n uint64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	num := new(big.Int).SetUint64(n)
+	want, err := p.serverEth.HeaderByNumber(ctx, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve server header %d: %v", p.name, n, err)
+	}
+	got, err := p.clientEth.HeaderByNumber(ctx, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve client header %d: %v", p.name, n, err)
+	}
+	if got.Hash() != want.Hash() {
+		return fmt.Errorf("%s: header %d mismatch, want %x, got %x", p.name, n, want.Hash(), got.Hash())
+	}
+	return nil
+}
+
+// State checks the balance, nonce, code and the given storage slots of the
+// account retrieved by the client match the server's at the given block.
+func State(cluster *simulator.Cluster, client, server int, account common.Address, number uint64, slots ...common.Hash) error {
+	p, err := dial(cluster, client, server)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	num := new(big.Int).SetUint64(number)
+	wantBalance, err := p.serverEth.BalanceAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve server balance: %v", p.name, err)
+	}
+	gotBalance, err := p.clientEth.BalanceAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve client balance: %v", p.name, err)
+	}
+	if gotBalance.Cmp(wantBalance) != 0 {
+		return fmt.Errorf("%s: balance mismatch of %x at %d, want %v, got %v", p.name, account, number, wantBalance, gotBalance)
+	}
+	wantNonce, err := p.serverEth.NonceAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve server nonce: %v", p.name, err)
+	}
+	gotNonce, err := p.clientEth.NonceAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve client nonce: %v", p.name, err)
+	}
+	if gotNonce != wantNonce {
+		return fmt.Errorf("%s: nonce mismatch of %x at %d, want %d, got %d", p.name, account, number, wantNonce, gotNonce)
+	}
+	wantCode, err := p.serverEth.CodeAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve server code: %v", p.name, err)
+	}
+	gotCode, err := p.clientEth.CodeAt(ctx, account, num)
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve client code: %v", p.name, err)
+	}
+	if !bytes.Equal(gotCode, wantCode) {
+		return fmt.Errorf("%s: code mismatch of %x at %d, want %x, got %x", p.name, account, number, wantCode, gotCode)
+	}
+	for _, slot := range slots {
+		want, err := p.serverEth.StorageAt(ctx, account, slot, num)
+		if err != nil {
+			return fmt.Errorf("%s: failed to retrieve server storage: %v", p.name, err)
+		}
+		got, err := p.clientEth.StorageAt(ctx, account, slot, num)
+		if err != nil {
+			return fmt.Errorf("%s: failed to retrieve client storage: %v", p.name, err)
+		}
+		if !bytes.Equal(got, want) {
+			return fmt.Errorf("%s: storage mismatch of %x slot %x at %d, want %x, got %x", p.name, account, slot, number, want, got)
+		}
+	}
+	return nil
+}
+
+// Receipts checks the receipts of all the transactions in the given block
+// fetched by the client via ODR match the server's.
+func Receipts(cluster *simulator.Cluster, client, server int, number uint64) error {
+	p, err := dial(cluster, client, server)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	block, err := p.serverEth.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve server block %d: %v", p.name, number, err)
+	}
+	for _, tx := range block.Transactions() {
+		want, err := p.serverEth.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return fmt.Errorf("%s: failed to retrieve server receipt %x: %v", p.name, tx.Hash(), err)
+		}
+		got, err := p.clientEth.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return fmt.Errorf("%s: failed to retrieve client receipt %x: %v", p.name, tx.Hash(), err)
+		}
+		if err := compareReceipt(got, want); err != nil {
+			return fmt.Errorf("%s: receipt mismatch of %x: %v", p.name, tx.Hash(), err)
+		}
+	}
+	return nil
+}
+
+// compareReceipt compares the consensus and the derived fields of receipts.
+func compareReceipt(got, want *types.Receipt) error {
+	switch {
+	case got.Status != want.Status:
+		return fmt.Errorf("status want %d, got %d", want.Status, got.Status)
+	case got.CumulativeGasUsed != want.CumulativeGasUsed:
+		return fmt.Errorf("cumulative gas want %d, got %d", want.CumulativeGasUsed, got.CumulativeGasUsed)
+	case got.GasUsed != want.GasUsed:
+		return fmt.Errorf("gas used want %d, got %d", want.GasUsed, got.GasUsed)
+	case got.Bloom != want.Bloom:
+		return fmt.Errorf("bloom mismatch")
+	case got.BlockHash != want.BlockHash:
+		return fmt.Errorf("block hash want %x, got %x", want.BlockHash, got.BlockHash)
+	case got.ContractAddress != want.ContractAddress:
+		return fmt.Errorf("contract address want %x, got %x", want.ContractAddress, got.ContractAddress)
+	case len(got.Logs) != len(want.Logs):
+		return fmt.Errorf("log number want %d, got %d", len(want.Logs), len(got.Logs))
+	}
+	for i := range got.Logs {
+		g, w := got.Logs[i], want.Logs[i]
+		if g.Address != w.Address || !bytes.Equal(g.Data, w.Data) || len(g.Topics) != len(w.Topics) {
+			return fmt.Errorf("log %d mismatch", i)
+		}
+		for j := range g.Topics {
+			if g.Topics[j] != w.Topics[j] {
+				return fmt.Errorf("log %d topic %d mismatch", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+// CHTRoot checks the checkpoint(section head, CHT root and bloom trie root)
+// of the given section used by the client matches the latest checkpoint
+// registered in the oracle contract, which is read via the server.
+func CHTRoot(cluster *simulator.Cluster, client, server int, section uint64) error {
+	address := cluster.OracleAddress()
+	if address == (common.Address{}) {
+		return errors.New("checkpoint oracle is not deployed")
+	}
+	p, err := dial(cluster, client, server)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+
+	caller, err := oracle.NewCheckpointOracleCaller(address, p.serverEth)
+	if err != nil {
+		return err
+	}
+	index, hash, _, err := caller.GetLatestCheckpoint(&bind.CallOpts{Context: ctx})
+	if err != nil {
+		return fmt.Errorf("%s: failed to retrieve registered checkpoint: %v", p.name, err)
+	}
+	if hash == (common.Hash{}) {
+		return fmt.Errorf("%s: no checkpoint is registered", p.name)
+	}
+	if index != section {
+		return fmt.Errorf("%s: section %d is not registered, the latest is %d", p.name, section, index)
+	}
+	var got [3]string
+	if err := p.client.CallContext(ctx, &got, "les_getCheckpoint", section); err != nil {
+		return fmt.Errorf("%s: failed to retrieve client checkpoint %d: %v", p.name, section, err)
+	}
+	checkpoint := &params.TrustedCheckpoint{
+		SectionIndex: section,
+		SectionHead:  common.HexToHash(got[0]),
+		CHTRoot:      common.HexToHash(got[1]),
+		BloomRoot:    common.HexToHash(got[2]),
+	}
+	if !checkpoint.HashEqual(hash) {
+		return fmt.Errorf("%s: checkpoint mismatch of section %d, registered %x, got %x(section head %s, CHT root %s, bloom trie root %s)",
+			p.name, section, hash, checkpoint.Hash(), got[0], got[1], got[2])
+	}
+	return nil
+}
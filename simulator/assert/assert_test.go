@@ -0,0 +1,68 @@
+package assert
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+func TestCompareReceipt(t *testing.T) {
+	newReceipt := func() *types.Receipt {
+		return &types.Receipt{
+			Status:            types.ReceiptStatusSuccessful,
+			CumulativeGasUsed: 42000,
+			GasUsed:           21000,
+			BlockHash:         common.HexToHash("0x01"),
+			Logs:              []*types.Log{{Address: common.HexToAddress("0x02"), Topics: []common.Hash{common.HexToHash("0x03")}}},
+		}
+	}
+	if err := compareReceipt(newReceipt(), newReceipt()); err != nil {
+		t.Fatalf("Unexpected mismatch: %v", err)
+	}
+	got := newReceipt()
+	got.Logs[0].Topics[0] = common.HexToHash("0x04")
+	if err := compareReceipt(got, newReceipt()); err == nil {
+		t.Fatalf("Topic mismatch is not detected")
+	}
+	got = newReceipt()
+	got.Status = types.ReceiptStatusFailed
+	if err := compareReceipt(got, newReceipt()); err == nil {
+		t.Fatalf("Status mismatch is not detected")
+	}
+}
+
+func TestCHTRoot(t *testing.T) {
+	for _, deployed := range []bool{false, true} {
+		cluster, err := simulator.NewCluster(&simulator.ClusterConfig{
+			Adapter:              "sim",
+			ChainID:              1337,
+			Blocks:               4,
+			DeployOracleContract: deployed,
+			ServerConfig:         []*simulator.ServerServiceConfig{{LightServ: 100, LightPeers: 10, LogVerbosity: log.LvlError}},
+			ClientConfig:         []*simulator.ClientServiceConfig{{LogVerbosity: log.LvlError}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to create cluster: %v", err)
+		}
+		if err := cluster.StartNodes(); err != nil {
+			cluster.Close()
+			t.Fatalf("Failed to start cluster: %v", err)
+		}
+		// The genesis is always available in the client
+		if err := HeaderChain(cluster, 0, 0, 0); err != nil {
+			t.Errorf("Unexpected header chain mismatch: %v", err)
+		}
+		want := "checkpoint oracle is not deployed"
+		if deployed {
+			want = "no checkpoint is registered"
+		}
+		if err := CHTRoot(cluster, 0, 0, 0); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Unexpected checkpoint error, want %q, got %v", want, err)
+		}
+		cluster.Close()
+	}
+}
@@ -0,0 +1,316 @@
+package simulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// LES message codes which are manipulated by the misbehaving nodes.
+const (
+	lesAnnounceMsg            = 0x01
+	lesGetBlockHeadersMsg     = 0x02
+	lesBlockHeadersMsg        = 0x03
+	lesGetBlockBodiesMsg      = 0x04
+	lesBlockBodiesMsg         = 0x05
+	lesGetReceiptsMsg         = 0x06
+	lesReceiptsMsg            = 0x07
+	lesGetCodeMsg             = 0x0a
+	lesCodeMsg                = 0x0b
+	lesGetProofsV2Msg         = 0x0f
+	lesProofsV2Msg            = 0x10
+	lesGetHelperTrieProofsMsg = 0x11
+	lesHelperTrieProofsMsg    = 0x12
+	lesSendTxV2Msg            = 0x13
+	lesGetTxStatusMsg         = 0x14
+	lesTxStatusMsg            = 0x15
+	lesStopMsg                = 0x16
+)
+
+// fakeHeadDistance is the number of blocks the fake head announced by the
+// misbehaving server is ahead of the real head.
+const fakeHeadDistance = 64
+
+// ServerBehavior makes the server misbehave when serving the LES clients, so
+// that the detection and dropping of bad servers by the clients can be tested.
+// All the misbehaviors can be combined.
+type ServerBehavior struct {
+	// InvalidProofs replaces the merkle proofs in the replies of the state
+	// and helper trie requests with garbage nodes.
+	InvalidProofs bool
+
+	// WithholdResponses drops all the replies, so that the client requests
+	// are timed out.
+	WithholdResponses bool
+
+	// ResponseDelay delays each reply by the given duration.
+	ResponseDelay time.Duration
+
+	// FakeHeads replaces the announced heads with the non-existent ones
+	// which are ahead of the real heads.
+	FakeHeads bool
+
+	// Overcharge reports the drained buffer value in the replies, as if the
+	// requests cost much more than the announced price.
+	Overcharge bool
+}
+
+// isLesReply returns whether the LES message is the reply which carries the
+// request id and the buffer value.
+func isLesReply(code uint64) bool {
+	switch code {
+	case lesBlockHeadersMsg, lesBlockBodiesMsg, lesReceiptsMsg, lesCodeMsg, lesProofsV2Msg, lesHelperTrieProofsMsg, lesTxStatusMsg:
+		return true
+	}
+	return false
+}
+
+// isLesRequest returns whether the LES message is the request sent by the
+// client which is charged by the server.
+func isLesRequest(code uint64) bool {
+	switch code {
+	case lesGetBlockHeadersMsg, lesGetBlockBodiesMsg, lesGetReceiptsMsg, lesGetCodeMsg, lesGetProofsV2Msg, lesGetHelperTrieProofsMsg, lesSendTxV2Msg, lesGetTxStatusMsg:
+		return true
+	}
+	return false
+}
+
+// lesReply is the common format of the LES replies.
+type lesReply struct {
+	ReqID, BV uint64
+	Data      rlp.RawValue
+}
+
+// lesAnnounce is the format of the LES announcement.
+type lesAnnounce struct {
+	Hash       common.Hash
+	Number     uint64
+	Td         *big.Int
+	ReorgDepth uint64
+	Update     rlp.RawValue
+}
+
+// wrapLes wraps the LES protocol of the node with the message read writer
+// created by the given function. It must be called after the protocol is
+// registered but before the node is started.
+func wrapLes(stack *node.Node, wrap func(rw p2p.MsgReadWriter) p2p.MsgReadWriter) {
+	srv := stack.Server()
+	for i := range srv.Protocols {
+		proto := &srv.Protocols[i]
+		if proto.Name != "les" {
+			continue
+		}
+		run := proto.Run
+		proto.Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return run(p, wrap(rw))
+		}
+	}
+}
+
+// Wrap makes the LES protocol of the server misbehave.
+func (b *ServerBehavior) Wrap(stack *node.Node) {
+	wrapLes(stack, func(rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+		return &serverBehaviorRW{MsgReadWriter: rw, behavior: b}
+	})
+}
+
+// serverBehaviorRW is the message read writer which manipulates the messages
+// sent by the server.
+type serverBehaviorRW struct {
+	p2p.MsgReadWriter
+	behavior *ServerBehavior
+}
+
+func (rw *serverBehaviorRW) WriteMsg(msg p2p.Msg) error {
+	b := rw.behavior
+	switch {
+	case msg.Code == lesAnnounceMsg && b.FakeHeads:
+		var announce lesAnnounce
+		if err := decodeMsg(&msg, &announce); err != nil {
+			return err
+		}
+		rand.Read(announce.Hash[:])
+		announce.Number += fakeHeadDistance
+		announce.Td = new(big.Int).Mul(announce.Td, big.NewInt(2))
+		return rw.write(msg.Code, &announce)
+
+	case isLesReply(msg.Code):
+		if b.WithholdResponses {
+			return msg.Discard()
+		}
+		if b.ResponseDelay > 0 {
+			time.Sleep(b.ResponseDelay)
+		}
+		if !b.Overcharge && !(b.InvalidProofs && (msg.Code == lesProofsV2Msg || msg.Code == lesHelperTrieProofsMsg)) {
+			break
+		}
+		var reply lesReply
+		if err := decodeMsg(&msg, &reply); err != nil {
+			return err
+		}
+		if b.Overcharge {
+			reply.BV = 0
+		}
+		if b.InvalidProofs {
+			data, err := invalidProofs(msg.Code, reply.Data)
+			if err != nil {
+				return err
+			}
+			reply.Data = data
+		}
+		return rw.write(msg.Code, &reply)
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+func (rw *serverBehaviorRW) write(code uint64, val interface{}) error {
+	msg, err := encodeMsg(code, val)
+	if err != nil {
+		return err
+	}
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// invalidProofs replaces the proof nodes in the reply data with a garbage node
+// which doesn't match any requested root.
+func invalidProofs(code uint64, data rlp.RawValue) (rlp.RawValue, error) {
+	garbage, err := rlp.EncodeToBytes([][]byte{[]byte("invalid"), []byte("proof")})
+	if err != nil {
+		return nil, err
+	}
+	nodes := []rlp.RawValue{garbage}
+	if code == lesProofsV2Msg {
+		return rlp.EncodeToBytes(nodes)
+	}
+	var proofs struct {
+		Proofs  []rlp.RawValue
+		AuxData []rlp.RawValue
+	}
+	if err := rlp.DecodeBytes(data, &proofs); err != nil {
+		return nil, err
+	}
+	proofs.Proofs = nodes
+	return rlp.EncodeToBytes(&proofs)
+}
+
+// decodeMsg decodes the message payload into the given value.
+func decodeMsg(msg *p2p.Msg, val interface{}) error {
+	payload, err := ioutil.ReadAll(io.LimitReader(msg.Payload, int64(msg.Size)))
+	if err != nil {
+		return err
+	}
+	return rlp.DecodeBytes(payload, val)
+}
+
+// encodeMsg creates the message with the RLP encoded value as the payload.
+func encodeMsg(code uint64, val interface{}) (p2p.Msg, error) {
+	payload, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		return p2p.Msg{}, err
+	}
+	return p2p.Msg{Code: code, Size: uint32(len(payload)), Payload: bytes.NewReader(payload), ReceivedAt: time.Now()}, nil
+}
+
+// ClientBehavior makes the client misbehave when requesting the LES servers,
+// so that the protection of the honest clients by the servers can be tested.
+// All the misbehaviors can be combined.
+type ClientBehavior struct {
+	// FloodFactor sends each request the given number of times, so that the
+	// client exceeds the buffer limit assigned by the server.
+	//
+	// The default value is 0 which means each request is sent once.
+	FloodFactor int
+
+	// MalformedRequests replaces the payload of the requests with garbage.
+	MalformedRequests bool
+
+	// IgnoreFlowControl hides the flow control feedback from the client, the
+	// buffer values in the replies are reported as full and the stop messages
+	// are dropped, so that the client keeps sending requests.
+	IgnoreFlowControl bool
+
+	// RefusePayment rejects all the signing requests of the client, so that
+	// no cheque is paid to the servers. It requires the clef to be enabled.
+	RefusePayment bool
+}
+
+// malformedPayload is the payload of the malformed requests, it's not a valid
+// RLP list.
+var malformedPayload = []byte{0xff, 0xff, 0xff, 0xff}
+
+// Wrap makes the LES protocol of the client misbehave.
+func (b *ClientBehavior) Wrap(stack *node.Node) {
+	wrapLes(stack, func(rw p2p.MsgReadWriter) p2p.MsgReadWriter {
+		return &clientBehaviorRW{MsgReadWriter: rw, behavior: b}
+	})
+}
+
+// clientBehaviorRW is the message read writer which manipulates the messages
+// exchanged by the client.
+type clientBehaviorRW struct {
+	p2p.MsgReadWriter
+	behavior *ClientBehavior
+}
+
+func (rw *clientBehaviorRW) ReadMsg() (p2p.Msg, error) {
+	for {
+		msg, err := rw.MsgReadWriter.ReadMsg()
+		if err != nil || !rw.behavior.IgnoreFlowControl {
+			return msg, err
+		}
+		switch {
+		case msg.Code == lesStopMsg:
+			if err := msg.Discard(); err != nil {
+				return msg, err
+			}
+			continue
+
+		case isLesReply(msg.Code):
+			var reply lesReply
+			if err := decodeMsg(&msg, &reply); err != nil {
+				return msg, err
+			}
+			reply.BV = math.MaxUint64
+			received := msg.ReceivedAt
+			if msg, err = encodeMsg(msg.Code, &reply); err != nil {
+				return msg, err
+			}
+			msg.ReceivedAt = received
+		}
+		return msg, nil
+	}
+}
+
+func (rw *clientBehaviorRW) WriteMsg(msg p2p.Msg) error {
+	b := rw.behavior
+	if !isLesRequest(msg.Code) || (b.FloodFactor <= 1 && !b.MalformedRequests) {
+		return rw.MsgReadWriter.WriteMsg(msg)
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(msg.Payload, int64(msg.Size)))
+	if err != nil {
+		return err
+	}
+	if b.MalformedRequests {
+		payload = malformedPayload
+	}
+	times := b.FloodFactor
+	if times < 1 {
+		times = 1
+	}
+	for i := 0; i < times; i++ {
+		err := rw.MsgReadWriter.WriteMsg(p2p.Msg{Code: msg.Code, Size: uint32(len(payload)), Payload: bytes.NewReader(payload)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package simulator
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestServerBehavior(t *testing.T) {
+	var (
+		in, out = p2p.MsgPipe()
+		rw      = &serverBehaviorRW{MsgReadWriter: in, behavior: &ServerBehavior{InvalidProofs: true, FakeHeads: true, Overcharge: true}}
+		node, _ = rlp.EncodeToBytes([][]byte{{0x01}})
+		head    = &lesAnnounce{Hash: common.Hash{0x01}, Number: 10, Td: big.NewInt(100), Update: rlp.EmptyList}
+	)
+	defer in.Close()
+
+	go func() {
+		p2p.Send(rw, lesProofsV2Msg, &lesReply{ReqID: 1, BV: 1000, Data: mustEncode(t, []rlp.RawValue{node})})
+		p2p.Send(rw, lesAnnounceMsg, head)
+	}()
+	msg, err := out.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read message, err %v", err)
+	}
+	var reply lesReply
+	if err := msg.Decode(&reply); err != nil {
+		t.Fatalf("Failed to decode reply, err %v", err)
+	}
+	if reply.ReqID != 1 || reply.BV != 0 {
+		t.Fatalf("Unexpected reply, reqid %d, bv %d", reply.ReqID, reply.BV)
+	}
+	var nodes []rlp.RawValue
+	if err := rlp.DecodeBytes(reply.Data, &nodes); err != nil {
+		t.Fatalf("Failed to decode proofs, err %v", err)
+	}
+	if len(nodes) != 1 || string(nodes[0]) == string(node) {
+		t.Fatalf("Proofs are not replaced")
+	}
+	msg, err = out.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read message, err %v", err)
+	}
+	var announce lesAnnounce
+	if err := msg.Decode(&announce); err != nil {
+		t.Fatalf("Failed to decode announcement, err %v", err)
+	}
+	if announce.Hash == head.Hash || announce.Number != head.Number+fakeHeadDistance || announce.Td.Cmp(head.Td) <= 0 {
+		t.Fatalf("Announcement is not faked, %+v", announce)
+	}
+}
+
+func TestServerWithholdResponses(t *testing.T) {
+	var (
+		in, out = p2p.MsgPipe()
+		rw      = &serverBehaviorRW{MsgReadWriter: in, behavior: &ServerBehavior{WithholdResponses: true}}
+	)
+	defer in.Close()
+
+	go func() {
+		p2p.Send(rw, lesBlockHeadersMsg, &lesReply{ReqID: 1, BV: 1000, Data: rlp.EmptyList})
+		p2p.Send(rw, lesAnnounceMsg, &lesAnnounce{Td: big.NewInt(1), Update: rlp.EmptyList})
+	}()
+	msg, err := out.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read message, err %v", err)
+	}
+	if msg.Code != lesAnnounceMsg {
+		t.Fatalf("Reply is not withheld, got message 0x%02x", msg.Code)
+	}
+}
+
+func TestClientBehavior(t *testing.T) {
+	var (
+		in, out = p2p.MsgPipe()
+		rw      = &clientBehaviorRW{MsgReadWriter: in, behavior: &ClientBehavior{FloodFactor: 3, MalformedRequests: true, IgnoreFlowControl: true}}
+	)
+	defer in.Close()
+
+	go p2p.Send(rw, lesGetBlockHeadersMsg, &lesReply{ReqID: 1})
+	for i := 0; i < 3; i++ {
+		msg, err := out.ReadMsg()
+		if err != nil {
+			t.Fatalf("Failed to read message, err %v", err)
+		}
+		var req lesReply
+		if msg.Code != lesGetBlockHeadersMsg || msg.Decode(&req) == nil {
+			t.Fatalf("Request %d is not malformed", i)
+		}
+	}
+	// The stop message is dropped and the buffer value is reported as full.
+	go func() {
+		p2p.Send(out, lesStopMsg, []uint64{1})
+		p2p.Send(out, lesBlockHeadersMsg, &lesReply{ReqID: 1, BV: 10, Data: rlp.EmptyList})
+	}()
+	msg, err := rw.ReadMsg()
+	if err != nil {
+		t.Fatalf("Failed to read message, err %v", err)
+	}
+	var reply lesReply
+	if err := msg.Decode(&reply); err != nil {
+		t.Fatalf("Failed to decode reply, err %v", err)
+	}
+	if msg.Code != lesBlockHeadersMsg || reply.BV != math.MaxUint64 {
+		t.Fatalf("Flow control feedback is not hidden, code 0x%02x, bv %d", msg.Code, reply.BV)
+	}
+}
+
+func mustEncode(t *testing.T, val interface{}) []byte {
+	blob, err := rlp.EncodeToBytes(val)
+	if err != nil {
+		t.Fatalf("Failed to encode, err %v", err)
+	}
+	return blob
+}
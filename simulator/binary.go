@@ -0,0 +1,102 @@
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// nodeBinaryEnv is the environment variable set when the node process has been
+// handed over to the custom node binary, it prevents the handover loop since
+// the custom binary constructs the same cluster again.
+const nodeBinaryEnv = "LES_SIMULATOR_NODE_BINARY"
+
+// nodeMetricsEnv is the environment variable set by the simulator process if
+// the metrics is required. The node processes spawned by the exec adapter
+// inherit it, since they don't share the cluster config of the simulator, e.g.
+// the command line flags are not passed through.
+const nodeMetricsEnv = "LES_SIMULATOR_METRICS"
+
+// resolveBinary returns the absolute path of the given node binary.
+func resolveBinary(binary string) (string, error) {
+	path, err := exec.LookPath(binary)
+	if err != nil {
+		return "", fmt.Errorf("invalid node binary %s: %v", binary, err)
+	}
+	return filepath.Abs(path)
+}
+
+// execNodeBinary replaces the current process with the custom node binary if
+// the process is spawned by the exec adapter for running a node which requires
+// it. The exec adapter always re-executes the current binary as "p2p-node"
+// with the comma separated lifecycle names as the first argument, all the
+// arguments, environment variables and file descriptors are passed through
+// so that the custom binary can take over the node as the child of the exec
+// adapter.
+//
+// If metrics is required by the config or by the simulator process, the
+// "--metrics" flag is appended to the arguments. It's the only way to enable
+// the go-ethereum metrics system, which is checked at the package
+// initialization.
+//
+// It's a noop if the current process is not a node process.
+func execNodeBinary(binaries map[string]string, metrics bool) {
+	if len(os.Args) < 2 || os.Args[0] != "p2p-node" {
+		if metrics {
+			os.Setenv(nodeMetricsEnv, "1")
+		}
+		return
+	}
+	if os.Getenv(nodeMetricsEnv) != "" {
+		metrics = true
+	}
+	self, err := os.Executable()
+	if err != nil {
+		log.Crit("Failed to resolve node binary", "err", err)
+	}
+	binary, args, env := nodeBinaryCommand(os.Args, os.Environ(), binaries, self, metrics)
+	if binary == "" {
+		return
+	}
+	if err := syscall.Exec(binary, args, env); err != nil {
+		log.Crit("Failed to execute node binary", "binary", binary, "err", err)
+	}
+}
+
+// nodeBinaryCommand returns the binary, arguments and environment variables
+// for handing over the node process, the returned binary is empty if the
+// current process shouldn't be handed over.
+func nodeBinaryCommand(args, env []string, binaries map[string]string, self string, metrics bool) (string, []string, []string) {
+	if len(args) < 2 || args[0] != "p2p-node" {
+		return "", nil, nil
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, nodeBinaryEnv+"=") && kv != nodeBinaryEnv+"=" {
+			return "", nil, nil
+		}
+	}
+	binary := ""
+	for _, name := range strings.Split(args[1], ",") {
+		if path, ok := binaries[name]; ok {
+			binary = path
+			break
+		}
+	}
+	if binary == "" && !metrics {
+		return "", nil, nil
+	}
+	if binary == "" {
+		binary = self
+	}
+	args = append([]string(nil), args...)
+	if metrics {
+		args = append(args, "--metrics")
+	}
+	env = append(append([]string(nil), env...), fmt.Sprintf("%s=%s", nodeBinaryEnv, binary))
+	return binary, args, env
+}
@@ -0,0 +1,76 @@
+package simulator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNodeBinaryCommand(t *testing.T) {
+	var (
+		self     = "/bin/simulator"
+		binaries = map[string]string{"les-server-1": "/bin/geth-dev"}
+		env      = []string{"HOME=/root", "_P2P_NODE_CONFIG={}"}
+	)
+	var tests = []struct {
+		args    []string
+		env     []string
+		metrics bool
+
+		binary  string
+		newArgs []string
+		newEnv  []string
+	}{
+		// Not a node process
+		{args: []string{"simulator", "les-server-1"}, env: env},
+		{args: []string{"p2p-node"}, env: env, metrics: true},
+
+		// Node process without custom binary
+		{args: []string{"p2p-node", "les-server-0", "id"}, env: env},
+
+		// Node process already handed over
+		{args: []string{"p2p-node", "les-server-1", "id"}, env: append(env, nodeBinaryEnv+"=/bin/geth-dev"), metrics: true},
+
+		// Node process with custom binary
+		{
+			args:    []string{"p2p-node", "les-server-1", "id"},
+			env:     env,
+			binary:  "/bin/geth-dev",
+			newArgs: []string{"p2p-node", "les-server-1", "id"},
+			newEnv:  append(env, nodeBinaryEnv+"=/bin/geth-dev"),
+		},
+		{
+			args:    []string{"p2p-node", "les-server-0,les-server-1", "id"},
+			env:     env,
+			metrics: true,
+			binary:  "/bin/geth-dev",
+			newArgs: []string{"p2p-node", "les-server-0,les-server-1", "id", "--metrics"},
+			newEnv:  append(env, nodeBinaryEnv+"=/bin/geth-dev"),
+		},
+		// Node process with metrics only
+		{
+			args:    []string{"p2p-node", "les-client-0", "id"},
+			env:     env,
+			metrics: true,
+			binary:  self,
+			newArgs: []string{"p2p-node", "les-client-0", "id", "--metrics"},
+			newEnv:  append(env, nodeBinaryEnv+"="+self),
+		},
+	}
+	for i, test := range tests {
+		args := append([]string(nil), test.args...)
+		binary, newArgs, newEnv := nodeBinaryCommand(args, test.env, binaries, self, test.metrics)
+		if binary != test.binary {
+			t.Errorf("test %d: unexpected binary, want %q, got %q", i, test.binary, binary)
+			continue
+		}
+		if !reflect.DeepEqual(newArgs, test.newArgs) {
+			t.Errorf("test %d: unexpected arguments, want %v, got %v", i, test.newArgs, newArgs)
+		}
+		if !reflect.DeepEqual(newEnv, test.newEnv) {
+			t.Errorf("test %d: unexpected environment, want %v, got %v", i, test.newEnv, newEnv)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("test %d: original arguments are modified, %v", i, args)
+		}
+	}
+}
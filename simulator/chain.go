@@ -0,0 +1,96 @@
+package simulator
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// ChainOverride replaces the initial chain of a single server, so that the
+// servers can disagree on the chain. The servers with the chain override are
+// not connected to the other servers, otherwise they would sync to the same
+// heaviest chain.
+type ChainOverride struct {
+	// Blocks is the pre-generated chain on top of the cluster genesis which
+	// replaces the shared initial chain entirely.
+	//
+	// The default value is nil which means the shared chain or the fork
+	// specified by `Fork` is used.
+	Blocks []*types.Block
+
+	// Fork is the identifier of the alternate fork, which shares the first
+	// `ForkAt` blocks with the shared chain and has the same length. The
+	// servers with the same fork identifier and `ForkAt` have the same fork.
+	//
+	// The default value is 0 which means no fork.
+	Fork int
+
+	// ForkAt is the number of the common blocks of the alternate fork and the
+	// shared chain. It's only meaningful when `Fork` is not 0.
+	ForkAt int
+
+	// Length truncates the chain to the given number of blocks.
+	//
+	// The default value is 0 which means the chain is not truncated.
+	Length int
+}
+
+// forkKey is the identifier of the generated alternate fork.
+type forkKey struct {
+	fork   int
+	forkAt int
+}
+
+// overrideChains returns the initial chains of the servers with the chain
+// override, keyed by the server index. The alternate forks are generated on
+// top of the shared chain in the given database.
+func overrideChains(servers []*ServerServiceConfig, config *params.ChainConfig, genesis *types.Block, db ethdb.Database, shared []*types.Block) (map[int][]*types.Block, error) {
+	var (
+		chains = make(map[int][]*types.Block)
+		forks  = make(map[forkKey][]*types.Block)
+	)
+	for index, server := range servers {
+		if server == nil || server.Chain == nil {
+			continue
+		}
+		override := server.Chain
+		chain := shared
+		switch {
+		case override.Blocks != nil:
+			chain = override.Blocks
+		case override.Fork != 0:
+			if override.ForkAt < 0 || override.ForkAt >= len(shared) {
+				return nil, fmt.Errorf("invalid fork point %d of %s, chain length %d", override.ForkAt, serverName(index), len(shared))
+			}
+			key := forkKey{fork: override.Fork, forkAt: override.ForkAt}
+			if _, ok := forks[key]; !ok {
+				parent := genesis
+				if override.ForkAt > 0 {
+					parent = shared[override.ForkAt-1]
+				}
+				// Mine the fork blocks with the distinct etherbase so that they
+				// differ from the shared chain.
+				coinbase := common.BigToAddress(big.NewInt(int64(override.Fork)))
+				blocks, _ := core.GenerateChain(config, parent, ethash.NewFaker(), db, len(shared)-override.ForkAt, func(i int, gen *core.BlockGen) {
+					gen.SetCoinbase(coinbase)
+				})
+				forks[key] = append(append([]*types.Block(nil), shared[:override.ForkAt]...), blocks...)
+			}
+			chain = forks[key]
+		}
+		if override.Length < 0 || override.Length > len(chain) {
+			return nil, fmt.Errorf("invalid chain length %d of %s", override.Length, serverName(index))
+		}
+		if override.Length > 0 {
+			chain = chain[:override.Length]
+		}
+		chains[index] = chain
+	}
+	return chains, nil
+}
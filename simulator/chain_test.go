@@ -0,0 +1,59 @@
+package simulator
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestOverrideChains(t *testing.T) {
+	var (
+		db    = rawdb.NewMemoryDatabase()
+		gspec = core.Genesis{
+			Config:     params.AllEthashProtocolChanges,
+			GasLimit:   4700000,
+			Difficulty: big.NewInt(5242880),
+		}
+		genesis   = gspec.MustCommit(db)
+		shared, _ = core.GenerateChain(gspec.Config, genesis, ethash.NewFaker(), db, 10, nil)
+	)
+	servers := []*ServerServiceConfig{
+		{},
+		{Chain: &ChainOverride{Length: 5}},
+		{Chain: &ChainOverride{Fork: 1, ForkAt: 4}},
+		{Chain: &ChainOverride{Fork: 1, ForkAt: 4}},
+		{Chain: &ChainOverride{Fork: 2, ForkAt: 4, Length: 8}},
+	}
+	chains, err := overrideChains(servers, gspec.Config, genesis, db, shared)
+	if err != nil {
+		t.Fatalf("Failed to override chains: %v", err)
+	}
+	if _, ok := chains[0]; ok {
+		t.Fatalf("Unexpected override of server without config")
+	}
+	if len(chains[1]) != 5 || chains[1][4].Hash() != shared[4].Hash() {
+		t.Fatalf("Unexpected truncated chain")
+	}
+	for _, index := range []int{2, 3} {
+		chain := chains[index]
+		if len(chain) != len(shared) {
+			t.Fatalf("Unexpected fork length, want %d, got %d", len(shared), len(chain))
+		}
+		if chain[3].Hash() != shared[3].Hash() || chain[4].Hash() == shared[4].Hash() {
+			t.Fatalf("Fork diverges at the wrong block")
+		}
+	}
+	if chains[2][9].Hash() != chains[3][9].Hash() {
+		t.Fatalf("Servers with the same fork have different chains")
+	}
+	if len(chains[4]) != 8 || chains[4][7].Hash() == chains[2][7].Hash() {
+		t.Fatalf("Unexpected alternate fork")
+	}
+	if _, err := overrideChains([]*ServerServiceConfig{{Chain: &ChainOverride{Fork: 1, ForkAt: 10}}}, gspec.Config, genesis, db, shared); err == nil {
+		t.Fatalf("Expected error for invalid fork point")
+	}
+}
@@ -1,11 +1,15 @@
 package simulator
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/big"
+	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
@@ -18,7 +22,10 @@ import (
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+	"github.com/ethereum/go-ethereum/p2p/enode"
 	"github.com/ethereum/go-ethereum/p2p/simulations"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
 	"github.com/ethereum/go-ethereum/params"
@@ -28,15 +35,41 @@ import (
 var masterKeyPrivate = "ab2f8cb941579e8b7336fd7e084e047e0f985b14f85485af37989487798403e8"
 
 type LesServer struct {
+	index  int
 	node   *simulations.Node
 	signer *ClefDaemon
 }
 
+// Name returns the name of the server used in the topology, e.g. s0.
+func (s *LesServer) Name() string { return serverName(s.index) }
+
+// Index returns the index of the server in the cluster.
+func (s *LesServer) Index() int { return s.index }
+
+// Node returns the simulation node of the server.
+func (s *LesServer) Node() *simulations.Node { return s.node }
+
+// Signer returns the external signer of the server, nil if clef is disabled.
+func (s *LesServer) Signer() *ClefDaemon { return s.signer }
+
 type LesClient struct {
+	index  int
 	node   *simulations.Node
 	signer *ClefDaemon
 }
 
+// Name returns the name of the client used in the topology, e.g. c0.
+func (c *LesClient) Name() string { return clientName(c.index) }
+
+// Index returns the index of the client in the cluster.
+func (c *LesClient) Index() int { return c.index }
+
+// Node returns the simulation node of the client.
+func (c *LesClient) Node() *simulations.Node { return c.node }
+
+// Signer returns the external signer of the client, nil if clef is disabled.
+func (c *LesClient) Signer() *ClefDaemon { return c.signer }
+
 type Conn struct {
 	From int // Client index
 	To   int // Server index
@@ -55,6 +88,28 @@ type ClusterConfig struct {
 	DeployOracleContract  bool // Whether deploy checkpoint oracle contract in blockchain
 	Prefunds              map[common.Address]*big.Int
 
+	// Miners is the list of indexes of the mining servers.
+	//
+	// The default value is nil which means only the first server mines.
+	Miners []int
+
+	// BlockInterval is the interval between the blocks mined by each miner.
+	//
+	// The default value is 0 which means the blocks are mined continuously.
+	BlockInterval time.Duration
+
+	// ManualMining is the flag whether the automatic mining is paused after
+	// the miners are started, so that the chain is only advanced by
+	// `MineBlocks` until `ResumeMining` is called.
+	ManualMining bool
+
+	// Transactions is the setting of the transaction generator, the sender
+	// accounts and the counter contract are added into the genesis if it's
+	// configured. The generator is launched by `StartTransactions`.
+	//
+	// The default value is nil which means no transaction is generated.
+	Transactions *TxConfig
+
 	// Account management configs
 	// KeystorePath is the path points to the keystore
 	KeystorePath string
@@ -66,6 +121,54 @@ type ClusterConfig struct {
 	// SigningRule is the rule for clef. It's only meaningful when `ClefEnabled`
 	// is true.
 	SigningRule []byte
+
+	// ClefTransport is the transport used by nodes to communicate with the
+	// external signer, it can be "ipc", "http" or "ws". It's only meaningful
+	// when `ClefEnabled` is true.
+	//
+	// The default value is empty which means the IPC is used.
+	ClefTransport string
+
+	// StartConcurrency is the maximum number of nodes which can be started
+	// in parallel. The default value is 0 which means nodes are started
+	// one by one.
+	StartConcurrency int
+
+	// ReadyTimeout is the maximum time for waiting a started node to be ready,
+	// which means the RPC is available and the LES protocol is registered.
+	//
+	// The default value is 0 which means `defaultReadyTimeout` is used.
+	ReadyTimeout time.Duration
+
+	// MetricsEnabled is the flag whether to enable the go-ethereum metrics
+	// system in nodes. The meters created at the package initialization, e.g.
+	// all the LES meters, are only available if the process is started with
+	// the "--metrics" flag. The flag is appended to the arguments of the
+	// exec adapter node processes.
+	//
+	// Nodes running with sim adapter share the metrics registry of the
+	// current process, so the metrics are process-wide rather than per-node,
+	// and the simulator binary itself must be started with "--metrics".
+	MetricsEnabled bool
+
+	// TraceDir is the directory for recording the protocol messages of all
+	// the nodes. Each node writes its trace into <name>.trace, e.g. s0.trace,
+	// and the mapping from node names to node IDs is written into nodes.json.
+	//
+	// The default value is empty which means the tracing is disabled.
+	TraceDir string
+
+	// ExecDir is the base directory for the exec adapter to store the node
+	// data, it's kept after the cluster is closed.
+	//
+	// The default value is empty which means a temporary directory is created
+	// and removed when the cluster is closed unless `KeepArtifacts` is set.
+	ExecDir string
+
+	// KeepArtifacts is the flag whether to keep the temporary directories
+	// (e.g. clef vaults, IPC sockets and exec adapter data) created by the
+	// cluster after it's closed. It's useful for debugging.
+	KeepArtifacts bool
 }
 
 type Cluster struct {
@@ -81,9 +184,60 @@ type Cluster struct {
 
 	// Signing state
 	keystore keystore.KeyStore
+
+	// Mining state
+	miners map[int]bool // Indexes of the mining servers
+	txgen  *txGenerator // Transaction generator, nil if not configured
+
+	// Node addition state
+	bcfg  *BlockchainConfig // Chain config shared by the added nodes
+	added *addedServices    // Life cycles of the added nodes, sim adapter only
+
+	// Event state
+	names map[enode.ID]string // Node names keyed by node ID
+	feed  event.Feed          // Feed of the cluster events
+	quit  chan struct{}
+	wg    sync.WaitGroup
+
+	// Temporary resources created by the cluster, removed in Close
+	tmpDirs []string
+	closed  bool
 }
 
 func NewCluster(config *ClusterConfig) (*Cluster, error) {
+	switch config.ClefTransport {
+	case "", "ipc", "http", "ws":
+	default:
+		return nil, fmt.Errorf("invalid clef transport %q", config.ClefTransport)
+	}
+	// Resolve the custom node binaries, they are only supported by the exec adapter.
+	binaries := make(map[string]string)
+	for index, server := range config.ServerConfig {
+		if server.Binary != "" {
+			binaries[fmt.Sprintf("les-server-%d", index)] = server.Binary
+		}
+	}
+	for index, client := range config.ClientConfig {
+		if client.Binary != "" {
+			binaries[fmt.Sprintf("les-client-%d", index)] = client.Binary
+		}
+	}
+	if len(binaries) > 0 && config.Adapter != "exec" {
+		return nil, errors.New("custom node binary requires exec adapter")
+	}
+	for name, binary := range binaries {
+		path, err := resolveBinary(binary)
+		if err != nil {
+			return nil, err
+		}
+		binaries[name] = path
+	}
+	// Hand over to the custom binary(or the current binary with metrics flag)
+	// if the current process is the node which requires it. It must be done
+	// before creating any resource(e.g. the pre-generated chain), which is
+	// never released after the process is replaced.
+	execNodeBinary(binaries, config.MetricsEnabled)
+
 	var (
 		gspec = core.Genesis{
 			Config:     params.AllEthashProtocolChanges,
@@ -100,6 +254,14 @@ func NewCluster(config *ClusterConfig) (*Cluster, error) {
 			gspec.Alloc[address] = core.GenesisAccount{Balance: fund}
 		}
 	}
+	var txgen *txGenerator
+	if config.Transactions != nil {
+		if err := config.Transactions.validate(); err != nil {
+			return nil, err
+		}
+		txgen = newTxGenerator(config.Transactions, gspec.Config)
+		txgen.alloc(gspec.Alloc)
+	}
 	db := rawdb.NewMemoryDatabase()
 	genesis := gspec.MustCommit(db)
 
@@ -132,8 +294,26 @@ func NewCluster(config *ClusterConfig) (*Cluster, error) {
 		})
 	}
 	bcfg := &BlockchainConfig{
-		Genesis: &gspec,
-		Chain:   blocks,
+		Genesis:       &gspec,
+		Chain:         blocks,
+		BlockInterval: config.BlockInterval,
+		ManualMining:  config.ManualMining,
+	}
+	chains, err := overrideChains(config.ServerConfig, gspec.Config, genesis, db, blocks)
+	if err != nil {
+		return nil, err
+	}
+	miners := make(map[int]bool)
+	if config.Miners == nil && len(config.ServerConfig) > 0 {
+		miners[0] = true
+	}
+	if config.Miners != nil {
+		for _, index := range config.Miners {
+			if index < 0 || index >= len(config.ServerConfig) {
+				return nil, fmt.Errorf("invalid miner index %d", index)
+			}
+			miners[index] = true
+		}
 	}
 	// Register all services
 	var (
@@ -141,53 +321,103 @@ func NewCluster(config *ClusterConfig) (*Cluster, error) {
 
 		serverDaemons []*ClefDaemon
 		clientDaemons []*ClefDaemon
+
+		tmpDirs []string
+		success bool
 	)
+	// Release all the created resources if the cluster can't be constructed.
+	defer func() {
+		if success {
+			return
+		}
+		for _, d := range append(serverDaemons, clientDaemons...) {
+			d.Stop()
+		}
+		if !config.KeepArtifacts {
+			removeDirs(tmpDirs)
+		}
+	}()
 	for index, server := range config.ServerConfig {
-		// Initialize clef daemon for each node if it's enabled.
-		if config.ClefEnabled {
-			clefPath, err := ioutil.TempDir("", fmt.Sprintf("server-clef-%d", index))
-			if err != nil {
-				return nil, err
+		if config.TraceDir != "" && server.TraceFile == "" {
+			traced := *server
+			traced.TraceFile = filepath.Join(config.TraceDir, serverName(index)+".trace")
+			server = &traced
+		}
+		serverBcfg := bcfg
+		if chain, ok := chains[index]; ok {
+			overridden := *bcfg
+			overridden.Chain = chain
+			serverBcfg = &overridden
+		}
+		services[fmt.Sprintf("les-server-%d", index)] = NewLesServerService(server, serverBcfg, miners[index])
+	}
+	for index, client := range config.ClientConfig {
+		if client.Behavior != nil && client.Behavior.RefusePayment && !config.ClefEnabled {
+			return nil, fmt.Errorf("%s: refusing payment requires clef", clientName(index))
+		}
+		if config.TraceDir != "" && client.TraceFile == "" {
+			traced := *client
+			traced.TraceFile = filepath.Join(config.TraceDir, clientName(index)+".trace")
+			client = &traced
+		}
+		services[fmt.Sprintf("les-client-%d", index)] = NewLesClientService(client, bcfg)
+	}
+	// Enable the metrics system before the nodes are created by the life
+	// cycles, both in the parent and the exec adapter child processes.
+	if config.MetricsEnabled {
+		metrics.Enabled = true
+	}
+	// It's necessary to register all the life cycles in order to use exec adapter,
+	// the registration runs the node and never returns in the adapter child
+	// process. The sim adapter runs the life cycles directly, skip registering
+	// so that multiple clusters can be created in the same process.
+	if config.Adapter == "exec" {
+		adapters.RegisterLifecycles(services)
+	}
+	// The life cycles of the sim adapter are fixed once it's created, so the
+	// nodes added afterwards run a single life cycle resolved by node name.
+	added := &addedServices{services: make(map[string]adapters.LifecycleConstructor)}
+	if config.Adapter == "sim" {
+		services[addedLifecycle] = added.construct
+	}
+	// Initialize clef daemon for each node if it's enabled. They are only
+	// created in the parent process, the nodes connect to them via URLs. The
+	// client which refuses to pay rejects all the signing requests.
+	if config.ClefEnabled {
+		for index, server := range config.ServerConfig {
+			d, dir, err := newClusterSigner(config, fmt.Sprintf("server-clef-%d", index), server.PaymentAddress, config.SigningRule)
+			if dir != "" {
+				tmpDirs = append(tmpDirs, dir)
 			}
-			d, err := NewClefDaemon(&ClefConfig{
-				Dir:      clefPath,
-				Keystore: config.KeystorePath,
-				ChainID:  config.ChainID,
-				Rules:    config.SigningRule,
-				Accounts: map[common.Address]string{server.PaymentAddress: ""},
-			})
 			if err != nil {
 				return nil, err
 			}
 			serverDaemons = append(serverDaemons, d)
 		}
-		services[fmt.Sprintf("les-server-%d", index)] = NewLesServerService(server, bcfg, index == 0)
-	}
-	for index, client := range config.ClientConfig {
-		if config.ClefEnabled {
-			// Initialize clef daemon for each node if it's enabled.
-			clefPath, err := ioutil.TempDir("", fmt.Sprintf("client-clef-%d", index))
-			if err != nil {
-				return nil, err
+		for index, client := range config.ClientConfig {
+			rules := config.SigningRule
+			if client.Behavior != nil && client.Behavior.RefusePayment {
+				rules = NewSigningRules(DenyAllRule())
+			}
+			d, dir, err := newClusterSigner(config, fmt.Sprintf("client-clef-%d", index), client.PaymentAddress, rules)
+			if dir != "" {
+				tmpDirs = append(tmpDirs, dir)
 			}
-			d, err := NewClefDaemon(&ClefConfig{
-				Dir:      clefPath,
-				Keystore: config.KeystorePath,
-				ChainID:  config.ChainID,
-				Rules:    config.SigningRule,
-				Accounts: map[common.Address]string{client.PaymentAddress: ""},
-			})
 			if err != nil {
 				return nil, err
 			}
 			clientDaemons = append(clientDaemons, d)
 		}
-		services[fmt.Sprintf("les-client-%d", index)] = NewLesClientService(client, bcfg)
 	}
-	// It's necessary to register all the life cycles in order to use exec adapter
-	adapters.RegisterLifecycles(services)
-
-	adapter, err := NewAdapter(config.Adapter, services)
+	adapterDir := config.ExecDir
+	if config.Adapter == "exec" && adapterDir == "" {
+		adapterDir, err = ioutil.TempDir("", "les-simulator-exec")
+		if err != nil {
+			return nil, err
+		}
+		tmpDirs = append(tmpDirs, adapterDir)
+	}
+	adapter, err := newAdapter(config.Adapter, adapterDir, services)
 	if err != nil {
 		return nil, err
 	}
@@ -198,43 +428,61 @@ func NewCluster(config *ClusterConfig) (*Cluster, error) {
 		config:         config,
 		oracleAddress:  oracleAddr,
 		lotteryAddress: lotteryAddr,
+		miners:         miners,
+		txgen:          txgen,
+		bcfg:           bcfg,
+		added:          added,
+		names:          make(map[enode.ID]string),
+		quit:           make(chan struct{}),
+		tmpDirs:        tmpDirs,
 	}
 	// Initialize all nodes
+	var serverNodes []*enode.Node
 	for index := range config.ServerConfig {
-		cfg := adapters.RandomNodeConfig()
-		cfg.Lifecycles = []string{fmt.Sprintf("les-server-%d", index)}
-		cfg.Properties = []string{"server"}
-		cfg.LogFile = config.ServerConfig[index].LogFile
-		cfg.LogVerbosity = config.ServerConfig[index].LogVerbosity
+		cfg := serverNodeConfig(index, config.ServerConfig[index], fmt.Sprintf("les-server-%d", index))
 
 		var signer *ClefDaemon
 		if config.ClefEnabled {
 			signer = serverDaemons[index]
-			cfg.ExternalSigner = serverDaemons[index].RPCURL()
+			cfg.ExternalSigner = signer.URL(config.ClefTransport)
 		}
 		server, err := net.NewNodeWithConfig(cfg)
 		if err != nil {
+			net.Shutdown()
 			return nil, err
 		}
-		cluster.servers = append(cluster.servers, &LesServer{node: server, signer: signer})
+		serverNodes = append(serverNodes, cfg.Node())
+		cluster.servers = append(cluster.servers, &LesServer{index: index, node: server, signer: signer})
+		cluster.names[server.ID()] = serverName(index)
 	}
 	for index := range config.ClientConfig {
-		cfg := adapters.RandomNodeConfig()
-		cfg.Lifecycles = []string{fmt.Sprintf("les-client-%d", index)}
-		cfg.Properties = []string{"client"}
-		cfg.LogFile = config.ClientConfig[index].LogFile
-		cfg.LogVerbosity = config.ClientConfig[index].LogVerbosity
-
+		cfg, err := clientNodeConfig(index, config.ClientConfig[index], fmt.Sprintf("les-client-%d", index), serverNodes)
+		if err != nil {
+			net.Shutdown()
+			return nil, err
+		}
 		var signer *ClefDaemon
 		if config.ClefEnabled {
-			signer = serverDaemons[index]
-			cfg.ExternalSigner = clientDaemons[index].RPCURL()
+			signer = clientDaemons[index]
+			cfg.ExternalSigner = signer.URL(config.ClefTransport)
 		}
 		client, err := net.NewNodeWithConfig(cfg)
 		if err != nil {
+			net.Shutdown()
+			return nil, err
+		}
+		cluster.clients = append(cluster.clients, &LesClient{index: index, node: client, signer: signer})
+		cluster.names[client.ID()] = clientName(index)
+	}
+	if config.TraceDir != "" {
+		var names []string
+		for _, name := range cluster.names {
+			names = append(names, name)
+		}
+		if err := cluster.writeTraceNodes(names); err != nil {
+			net.Shutdown()
 			return nil, err
 		}
-		cluster.clients = append(cluster.clients, &LesClient{node: client, signer: signer})
 	}
 	// Register system level contracts
 	if lotteryAddr != (common.Address{}) {
@@ -247,41 +495,237 @@ func NewCluster(config *ClusterConfig) (*Cluster, error) {
 			Threshold: 1,
 		}
 	}
+	cluster.wg.Add(1)
+	go cluster.eventLoop()
+
+	success = true
 	return cluster, nil
 }
 
-func (cluster *Cluster) StartNodes() error {
-	cluster.lock.Lock()
-	defer cluster.lock.Unlock()
+// serverNodeConfig returns the node config of the server with the given index
+// which runs the given life cycle.
+func serverNodeConfig(index int, config *ServerServiceConfig, lifecycle string) *adapters.NodeConfig {
+	cfg := adapters.RandomNodeConfig()
+	cfg.Name = serverName(index)
+	cfg.Lifecycles = []string{lifecycle}
+	cfg.Properties = []string{"server"}
+	cfg.LogFile = config.LogFile
+	cfg.LogVerbosity = config.LogVerbosity
+	return cfg
+}
 
+// clientNodeConfig returns the node config of the client with the given index
+// which runs the given life cycle. The trusted and pinned servers referenced
+// by index are resolved to the enode URLs of the given servers.
+func clientNodeConfig(index int, config *ClientServiceConfig, lifecycle string, servers []*enode.Node) (*adapters.NodeConfig, error) {
+	cfg := adapters.RandomNodeConfig()
+	cfg.Name = clientName(index)
+	cfg.Lifecycles = []string{lifecycle}
+	cfg.Properties = []string{"client"}
+	cfg.LogFile = config.LogFile
+	cfg.LogVerbosity = config.LogVerbosity
+
+	// Resolve the referenced trusted servers to the enode URLs, the
+	// configured URLs are passed via the service config directly.
+	trusted, err := resolveTrustedServers(config, servers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", clientName(index), err)
+	}
+	for _, url := range trusted {
+		cfg.Properties = append(cfg.Properties, trustedServerProperty+url)
+	}
+	// Resolve the pinned servers to the enode URLs.
+	for _, pinned := range config.PinnedServers {
+		if pinned < 0 || pinned >= len(servers) {
+			return nil, fmt.Errorf("invalid pinned server index %d of %s", pinned, clientName(index))
+		}
+		cfg.Properties = append(cfg.Properties, pinnedServerProperty+servers[pinned].URLv4())
+	}
+	return cfg, nil
+}
+
+// newClusterSigner creates the clef daemon in a new temporary directory for
+// managing the given account. The created directory is returned even if the
+// daemon fails to start so that it can be cleaned up.
+func newClusterSigner(config *ClusterConfig, name string, account common.Address, rules []byte) (*ClefDaemon, string, error) {
+	dir, err := ioutil.TempDir("", name)
+	if err != nil {
+		return nil, "", err
+	}
+	d, err := NewClefDaemon(&ClefConfig{
+		Dir:         dir,
+		Keystore:    config.KeystorePath,
+		ChainID:     config.ChainID,
+		Rules:       rules,
+		Accounts:    map[common.Address]string{account: ""},
+		HTTPEnabled: config.ClefTransport == "http",
+		WSEnabled:   config.ClefTransport == "ws",
+	})
+	if err != nil {
+		return nil, dir, err
+	}
+	return d, dir, nil
+}
+
+// writeTraceNodes writes the mapping from node names to node IDs into the trace
+// directory, which is used for analyzing the traces. The traces of the given
+// nodes left by the previous run are removed since the tracers append to the
+// existing files.
+func (cluster *Cluster) writeTraceNodes(fresh []string) error {
+	if err := os.MkdirAll(cluster.config.TraceDir, 0755); err != nil {
+		return err
+	}
+	for _, name := range fresh {
+		if err := os.Remove(filepath.Join(cluster.config.TraceDir, name+".trace")); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	nodes := make(map[string]enode.ID)
 	for _, server := range cluster.servers {
-		cluster.network.Start(server.node.ID())
+		nodes[server.Name()] = server.node.ID()
 	}
-	log.Info("Started all servers")
 	for _, client := range cluster.clients {
-		cluster.network.Start(client.node.ID())
+		nodes[client.Name()] = client.node.ID()
 	}
-	log.Info("Started all clients")
-	return nil
+	blob, err := json.MarshalIndent(nodes, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cluster.config.TraceDir, TraceNodesFile), blob, 0644)
+}
+
+// removeDirs removes all the given directories along with the contents.
+func removeDirs(dirs []string) {
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn("Failed to remove directory", "dir", dir, "err", err)
+		}
+	}
+}
+
+// StartNodes starts all the servers first and then all the clients. Each
+// node is waited until it's ready for serving. All the failures are collected
+// and returned as a MultiError which names the failed nodes.
+func (cluster *Cluster) StartNodes() error {
+	// The lock is only held for collecting the nodes, waiting for them to be
+	// ready can take long and shouldn't block the accessors.
+	cluster.lock.RLock()
+	var servers, clients []*simulations.Node
+	for _, server := range cluster.servers {
+		servers = append(servers, server.node)
+	}
+	for _, client := range cluster.clients {
+		clients = append(clients, client.node)
+	}
+	cluster.lock.RUnlock()
+
+	var errs MultiError
+	errs = append(errs, cluster.startNodes(servers, serverName)...)
+	log.Info("Started all servers", "failures", len(errs))
+
+	errs = append(errs, cluster.startNodes(clients, clientName)...)
+	log.Info("Started all clients", "failures", len(errs))
+	return errs.ErrorOrNil()
+}
+
+// startNodes starts the given nodes with the configured concurrency and waits
+// them to be ready.
+func (cluster *Cluster) startNodes(nodes []*simulations.Node, name func(int) string) MultiError {
+	var (
+		errs    MultiError
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		limit   = cluster.config.StartConcurrency
+		timeout = cluster.config.ReadyTimeout
+	)
+	if limit <= 0 {
+		limit = 1
+	}
+	if timeout == 0 {
+		timeout = defaultReadyTimeout
+	}
+	sema := make(chan struct{}, limit)
+	for index, node := range nodes {
+		wg.Add(1)
+		sema <- struct{}{}
+		go func(index int, node *simulations.Node) {
+			defer func() {
+				<-sema
+				wg.Done()
+			}()
+			err := cluster.network.Start(node.ID())
+			if err == nil {
+				err = waitNodeReady(node, timeout)
+			}
+			if err != nil {
+				lock.Lock()
+				errs = append(errs, &NodeError{Node: name(index), Err: err})
+				lock.Unlock()
+			}
+		}(index, node)
+	}
+	wg.Wait()
+	errs.sort()
+	return errs
 }
 
+// StopNodes stops all the running nodes and the signers, the nodes which are
+// already stopped are skipped. All the failures are collected and returned as
+// a MultiError which names the failed nodes.
 func (cluster *Cluster) StopNodes() error {
 	cluster.lock.Lock()
 	defer cluster.lock.Unlock()
 
-	for _, server := range cluster.servers {
-		cluster.network.Stop(server.node.ID())
-		if server.signer != nil {
-			server.signer.Stop()
+	// The clients are stopped first, so that no client dials the stopping
+	// server. The les server panics if a peer is added while it's stopping.
+	var errs MultiError
+	for index, client := range cluster.clients {
+		if client.node.Up() {
+			if err := cluster.network.Stop(client.node.ID()); err != nil {
+				errs = append(errs, &NodeError{Node: clientName(index), Err: err})
+			}
 		}
-	}
-	for _, client := range cluster.clients {
-		cluster.network.Stop(client.node.ID())
 		if client.signer != nil {
 			client.signer.Stop()
 		}
 	}
-	return nil
+	for index, server := range cluster.servers {
+		if server.node.Up() {
+			if err := cluster.network.Stop(server.node.ID()); err != nil {
+				errs = append(errs, &NodeError{Node: serverName(index), Err: err})
+			}
+		}
+		if server.signer != nil {
+			server.signer.Stop()
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Close stops all the nodes and signers, shuts down the network and removes
+// all the temporary resources created by the cluster unless `KeepArtifacts`
+// is configured. The cluster is not usable anymore after closing.
+func (cluster *Cluster) Close() error {
+	cluster.lock.Lock()
+	if cluster.closed {
+		cluster.lock.Unlock()
+		return nil
+	}
+	cluster.closed = true
+	cluster.lock.Unlock()
+
+	cluster.StopTransactions()
+	err := cluster.StopNodes()
+	cluster.network.Shutdown()
+	close(cluster.quit)
+	cluster.wg.Wait()
+
+	if cluster.config.KeepArtifacts {
+		log.Info("Kept cluster artifacts", "dirs", cluster.tmpDirs)
+		return err
+	}
+	removeDirs(cluster.tmpDirs)
+	return err
 }
 
 func (cluster *Cluster) Connect() error {
@@ -314,9 +758,12 @@ func (cluster *Cluster) Connect() error {
 			log.Info("Setup the connection", "client", conn.From, "server", conn.To)
 		}
 	}
-	// Connect servers together
+	// Connect servers together, except the ones with chain override
 	for i := range cluster.servers {
 		for j := i + 1; j < len(cluster.servers); j++ {
+			if cluster.isolated(i) || cluster.isolated(j) {
+				continue
+			}
 			if err := cluster.network.Connect(cluster.servers[i].node.ID(), cluster.servers[j].node.ID()); err != nil {
 				return err
 			}
@@ -356,6 +803,9 @@ func (cluster *Cluster) Disconnect() error {
 	// Disconnect servers
 	for i := range cluster.servers {
 		for j := i + 1; j < len(cluster.servers); j++ {
+			if cluster.isolated(i) || cluster.isolated(j) {
+				continue
+			}
 			if err := cluster.network.Disconnect(cluster.servers[i].node.ID(), cluster.servers[j].node.ID()); err != nil {
 				return err
 			}
@@ -364,17 +814,68 @@ func (cluster *Cluster) Disconnect() error {
 	return nil
 }
 
+// isolated returns whether the server is not connected to the other servers,
+// which is the case if its chain is overridden.
+func (cluster *Cluster) isolated(index int) bool {
+	// The added servers always share the chain
+	if index >= len(cluster.config.ServerConfig) {
+		return false
+	}
+	server := cluster.config.ServerConfig[index]
+	return server != nil && server.Chain != nil
+}
+
+// Adapter returns the type of the node adapter, sim or exec.
+func (cluster *Cluster) Adapter() string {
+	return cluster.config.Adapter
+}
+
 func (cluster *Cluster) Network() *simulations.Network {
 	return cluster.network
 }
 
+// Servers returns all the servers in the cluster.
+func (cluster *Cluster) Servers() []*LesServer {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	return append([]*LesServer(nil), cluster.servers...)
+}
+
+// Clients returns all the clients in the cluster.
+func (cluster *Cluster) Clients() []*LesClient {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	return append([]*LesClient(nil), cluster.clients...)
+}
+
+// NewAdapter creates the node adapter with the given type. The exec adapter
+// stores the node data in a new temporary directory.
 func NewAdapter(typ string, services adapters.LifecycleConstructors) (adapters.NodeAdapter, error) {
+	var dir string
+	if typ == "exec" {
+		tmpdir, err := ioutil.TempDir("", "")
+		if err != nil {
+			return nil, err
+		}
+		dir = tmpdir
+	}
+	return newAdapter(typ, dir, services)
+}
+
+// newAdapter creates the node adapter with the given type. The dir is the base
+// directory for the exec adapter to store the node data, it's ignored by the
+// other adapters.
+func newAdapter(typ string, dir string, services adapters.LifecycleConstructors) (adapters.NodeAdapter, error) {
 	switch typ {
 	case "sim":
 		return adapters.NewSimAdapter(services), nil
 	case "exec":
-		tmpdir, _ := ioutil.TempDir("", "")
-		return adapters.NewExecAdapter(tmpdir), nil
+		if dir == "" {
+			return nil, errors.New("no directory specified for exec adapter")
+		}
+		return adapters.NewExecAdapter(dir), nil
 	default:
 		return nil, errors.New("invalid adapter")
 	}
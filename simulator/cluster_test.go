@@ -0,0 +1,69 @@
+package simulator
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// newTestCluster creates and starts the cluster with the sim adapter, the
+// given function can adjust the config before the cluster is created.
+func newTestCluster(t *testing.T, servers, clients int, adjust func(config *ClusterConfig)) *Cluster {
+	t.Helper()
+
+	config := &ClusterConfig{
+		Adapter: "sim",
+		ChainID: 1337,
+		Blocks:  4,
+	}
+	for i := 0; i < servers; i++ {
+		config.ServerConfig = append(config.ServerConfig, &ServerServiceConfig{
+			LightServ:    100,
+			LightPeers:   10,
+			LogVerbosity: log.LvlError,
+		})
+	}
+	for i := 0; i < clients; i++ {
+		config.ClientConfig = append(config.ClientConfig, &ClientServiceConfig{
+			LogVerbosity: log.LvlError,
+		})
+	}
+	if adjust != nil {
+		adjust(config)
+	}
+	cluster, err := NewCluster(config)
+	if err != nil {
+		t.Fatalf("Failed to create cluster: %v", err)
+	}
+	if err := cluster.StartNodes(); err != nil {
+		cluster.Close()
+		t.Fatalf("Failed to start cluster: %v", err)
+	}
+	return cluster
+}
+
+func TestCloseStoppedNodes(t *testing.T) {
+	cluster := newTestCluster(t, 2, 1, nil)
+	if err := cluster.StopNodes(); err != nil {
+		t.Fatalf("Failed to stop nodes: %v", err)
+	}
+	if err := cluster.Close(); err != nil {
+		t.Fatalf("Failed to close cluster after stopping nodes: %v", err)
+	}
+
+	// Stop a single node externally, e.g. via the simulation API
+	cluster = newTestCluster(t, 2, 1, nil)
+	if err := cluster.Network().Stop(cluster.Servers()[1].Node().ID()); err != nil {
+		t.Fatalf("Failed to stop node: %v", err)
+	}
+	if err := cluster.Close(); err != nil {
+		t.Fatalf("Failed to close cluster with stopped node: %v", err)
+	}
+}
+
+func TestInvalidClefTransport(t *testing.T) {
+	_, err := NewCluster(&ClusterConfig{Adapter: "sim", ChainID: 1337, ClefEnabled: true, ClefTransport: "htp"})
+	if err == nil || err.Error() != `invalid clef transport "htp"` {
+		t.Fatalf("Unexpected error for invalid transport: %v", err)
+	}
+}
@@ -0,0 +1,301 @@
+package simulator
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// EventType is the type of the cluster event.
+type EventType string
+
+const (
+	EventNodeUp         EventType = "node-up"
+	EventNodeDown       EventType = "node-down"
+	EventPeerConnected  EventType = "peer-connected"
+	EventPeerDropped    EventType = "peer-dropped"
+	EventNewHead        EventType = "new-head"
+	EventSignerApproved EventType = "signer-approved"
+	EventSignerRejected EventType = "signer-rejected"
+)
+
+// eventSubscriptionBuf is the buffer size of the event channels.
+const eventSubscriptionBuf = 64
+
+// Event is the cluster lifecycle, peer or signer event.
+type Event struct {
+	Type EventType
+	Time time.Time
+	Node string // Name of the node which emits the event, e.g. s0 or c1
+
+	Peer     string          // Name of the remote node, only for peer events
+	Reason   string          // Reason of the peer drop, only for peer dropped event
+	Head     *types.Header   // New head, only for new head event
+	Decision *SignerDecision // Signer decision, only for signer events
+}
+
+// EventFilter selects the events to be delivered, the empty field matches all.
+type EventFilter struct {
+	Types []EventType
+	Nodes []string
+}
+
+func (f *EventFilter) match(ev *Event) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Types) > 0 {
+		var found bool
+		for _, typ := range f.Types {
+			if typ == ev.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Nodes) > 0 {
+		for _, name := range f.Nodes {
+			if name == ev.Node {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// EventSubscription is the subscription of the cluster events.
+type EventSubscription struct {
+	event.Subscription
+	dropped uint64 // Number of the dropped events, accessed atomically
+}
+
+// Dropped returns the number of the events which are dropped because the
+// subscriber doesn't read the channel in time.
+func (s *EventSubscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Subscribe returns the channel of the cluster events which match the given
+// filter. The nil filter matches all events. The channel is closed when the
+// subscription is unsubscribed or the cluster is closed.
+//
+// The events are dropped if the channel is full, so that the slow subscriber
+// never blocks the cluster, see EventSubscription.Dropped.
+func (cluster *Cluster) Subscribe(filter *EventFilter) (<-chan *Event, *EventSubscription) {
+	var (
+		in  = make(chan *Event, eventSubscriptionBuf)
+		out = make(chan *Event, eventSubscriptionBuf)
+		sub = &EventSubscription{Subscription: cluster.feed.Subscribe(in)}
+	)
+	go func() {
+		defer close(out)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev := <-in:
+				if !filter.match(ev) {
+					continue
+				}
+				select {
+				case out <- ev:
+				default:
+					if atomic.AddUint64(&sub.dropped, 1) == 1 {
+						log.Warn("Dropping cluster events for slow subscriber", "type", ev.Type, "node", ev.Node)
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-cluster.quit:
+				return
+			}
+		}
+	}()
+	return out, sub
+}
+
+// eventLoop converts the simulation network events and signer decisions into
+// cluster events until the cluster is closed.
+func (cluster *Cluster) eventLoop() {
+	defer cluster.wg.Done()
+
+	var (
+		netEvents = make(chan *simulations.Event, eventSubscriptionBuf)
+		netSub    = cluster.network.Events().Subscribe(netEvents)
+		decisions = make(chan *namedDecision, eventSubscriptionBuf)
+		watchers  = make(map[enode.ID]chan struct{})
+	)
+	defer netSub.Unsubscribe()
+
+	for _, server := range cluster.servers {
+		if server.signer != nil {
+			cluster.wg.Add(1)
+			go cluster.forwardDecisions(server.Name(), server.signer, decisions)
+		}
+	}
+	for _, client := range cluster.clients {
+		if client.signer != nil {
+			cluster.wg.Add(1)
+			go cluster.forwardDecisions(client.Name(), client.signer, decisions)
+		}
+	}
+	for {
+		select {
+		case ev := <-netEvents:
+			if ev.Type != simulations.EventTypeNode || ev.Node == nil {
+				continue
+			}
+			id := ev.Node.ID()
+			name, ok := cluster.knownName(id)
+			if !ok {
+				continue
+			}
+			if ev.Node.Up() {
+				if _, running := watchers[id]; running {
+					continue
+				}
+				quit := make(chan struct{})
+				watchers[id] = quit
+
+				cluster.wg.Add(1)
+				go cluster.watchNode(name, ev.Node, quit)
+				cluster.feed.Send(&Event{Type: EventNodeUp, Time: ev.Time, Node: name})
+			} else {
+				quit, running := watchers[id]
+				if !running {
+					continue
+				}
+				close(quit)
+				delete(watchers, id)
+				cluster.feed.Send(&Event{Type: EventNodeDown, Time: ev.Time, Node: name})
+			}
+
+		case d := <-decisions:
+			typ := EventSignerRejected
+			if d.Approved {
+				typ = EventSignerApproved
+			}
+			cluster.feed.Send(&Event{Type: typ, Time: d.Time, Node: d.node, Decision: d.SignerDecision})
+
+		case <-netSub.Err():
+			return
+
+		case <-cluster.quit:
+			for _, quit := range watchers {
+				close(quit)
+			}
+			return
+		}
+	}
+}
+
+// namedDecision is the signer decision along with the name of node which
+// owns the signer.
+type namedDecision struct {
+	*SignerDecision
+	node string
+}
+
+func (cluster *Cluster) forwardDecisions(name string, signer *ClefDaemon, sink chan<- *namedDecision) {
+	defer cluster.wg.Done()
+
+	ch := make(chan *SignerDecision, eventSubscriptionBuf)
+	sub := signer.SubscribeDecisions(ch)
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case d := <-ch:
+			select {
+			case sink <- &namedDecision{SignerDecision: d, node: name}:
+			case <-cluster.quit:
+				return
+			}
+		case <-cluster.quit:
+			return
+		}
+	}
+}
+
+// watchNode subscribes to the peer events and new heads of the running node
+// and converts them into cluster events, until the node is stopped.
+func (cluster *Cluster) watchNode(name string, node *simulations.Node, quit chan struct{}) {
+	defer cluster.wg.Done()
+
+	client, err := node.Client()
+	if err != nil {
+		log.Warn("Failed to watch node", "node", name, "err", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peerEvents := make(chan *p2p.PeerEvent, eventSubscriptionBuf)
+	peerSub, err := client.Subscribe(ctx, "admin", peerEvents, "peerEvents")
+	if err != nil {
+		log.Warn("Failed to subscribe peer events", "node", name, "err", err)
+		return
+	}
+	defer peerSub.Unsubscribe()
+
+	heads := make(chan *types.Header, eventSubscriptionBuf)
+	headSub, err := client.EthSubscribe(ctx, heads, "newHeads")
+	if err != nil {
+		log.Warn("Failed to subscribe new heads", "node", name, "err", err)
+		return
+	}
+	defer headSub.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-peerEvents:
+			peer := cluster.nodeName(ev.Peer)
+			switch ev.Type {
+			case p2p.PeerEventTypeAdd:
+				cluster.feed.Send(&Event{Type: EventPeerConnected, Time: time.Now(), Node: name, Peer: peer})
+			case p2p.PeerEventTypeDrop:
+				cluster.feed.Send(&Event{Type: EventPeerDropped, Time: time.Now(), Node: name, Peer: peer, Reason: ev.Error})
+			}
+
+		case head := <-heads:
+			cluster.feed.Send(&Event{Type: EventNewHead, Time: time.Now(), Node: name, Head: head})
+
+		case <-peerSub.Err():
+			return
+		case <-headSub.Err():
+			return
+		case <-quit:
+			return
+		}
+	}
+}
+
+// nodeName returns the name of the node with given ID, the abbreviated ID
+// is returned if the node is not in the cluster.
+func (cluster *Cluster) nodeName(id enode.ID) string {
+	if name, ok := cluster.knownName(id); ok {
+		return name
+	}
+	return id.TerminalString()
+}
+
+// knownName returns the name of the node with given ID if it's in the cluster,
+// the nodes can be added at runtime.
+func (cluster *Cluster) knownName(id enode.ID) (string, bool) {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	name, ok := cluster.names[id]
+	return name, ok
+}
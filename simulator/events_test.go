@@ -0,0 +1,53 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventFilter(t *testing.T) {
+	var cases = []struct {
+		filter   *EventFilter
+		event    *Event
+		expected bool
+	}{
+		{nil, &Event{Type: EventNodeUp, Node: "s0"}, true},
+		{&EventFilter{}, &Event{Type: EventNodeUp, Node: "s0"}, true},
+		{&EventFilter{Types: []EventType{EventNewHead}}, &Event{Type: EventNodeUp, Node: "s0"}, false},
+		{&EventFilter{Types: []EventType{EventNewHead}}, &Event{Type: EventNewHead, Node: "c0"}, true},
+		{&EventFilter{Nodes: []string{"c0", "c1"}}, &Event{Type: EventNewHead, Node: "c1"}, true},
+		{&EventFilter{Types: []EventType{EventNewHead}, Nodes: []string{"c0"}}, &Event{Type: EventNewHead, Node: "s0"}, false},
+	}
+	for i, c := range cases {
+		if got := c.filter.match(c.event); got != c.expected {
+			t.Fatalf("Case %d: unexpected result, want %v, got %v", i, c.expected, got)
+		}
+	}
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	cluster := newTestCluster(t, 1, 1, nil)
+
+	// Subscribe without reading the channel at all
+	_, sub := cluster.Subscribe(nil)
+	defer sub.Unsubscribe()
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 4*eventSubscriptionBuf; i++ {
+			cluster.feed.Send(&Event{Type: EventNewHead, Time: time.Now(), Node: "s0"})
+		}
+		done <- cluster.Close()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Failed to close cluster: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Cluster is blocked by the slow subscriber")
+	}
+	if sub.Dropped() == 0 {
+		t.Fatal("Events should be dropped for the slow subscriber")
+	}
+}
@@ -0,0 +1,323 @@
+package metrics
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+const (
+	// PeerCount is the metric name of the number of connected peers.
+	PeerCount = "peers"
+
+	// HeadNumber is the metric name of the local chain head number.
+	HeadNumber = "head"
+
+	// ProcessSeries is the name and role of the series which holds the
+	// process-wide go-ethereum metrics, see `Collector`.
+	ProcessSeries = "process"
+)
+
+var errNodeDown = errors.New("node is down")
+
+// DefaultPrefixes is the default list of go-ethereum metric prefixes to be
+// collected, which covers:
+// - LES message counts per type
+// - request serve time
+// - LES connections and server events
+//
+// The flow-control buffer values are not collected. The server capacity and
+// recharge are gauges, which `debug_metrics` doesn't report, and the client
+// buffers are not recorded as metrics at all.
+var DefaultPrefixes = []string{
+	"les/misc/in/packets/",
+	"les/misc/out/packets/",
+	"les/misc/serve/",
+	"les/server/",
+	"les/connection/",
+}
+
+// Config contains the settings of the collector.
+type Config struct {
+	// Interval is the time between two samplings.
+	//
+	// The default value is 1 second.
+	Interval time.Duration
+
+	// Prefixes is the list of go-ethereum metric prefixes to be collected.
+	//
+	// The default value is DefaultPrefixes.
+	Prefixes []string
+}
+
+// Sample is the metric values of a node at the specific time.
+type Sample struct {
+	Time   time.Time          `json:"time"`
+	Values map[string]float64 `json:"values"`
+}
+
+// Series is the time series of the samples of a node.
+type Series struct {
+	Node    string    `json:"node"` // Name of the node, e.g. s0 or c1
+	Role    string    `json:"role"` // Role of the node, server or client
+	Samples []*Sample `json:"samples"`
+}
+
+// node is a node to be sampled.
+type node struct {
+	name  string
+	role  string
+	index int
+	node  *simulations.Node
+}
+
+// Collector periodically pulls the metrics from all the nodes in the cluster
+// via RPC and stores them as the time series per node.
+//
+// The LES metrics are collected via `debug_metrics`, which requires the
+// metrics system enabled in the node, see `ClusterConfig.MetricsEnabled`.
+// With the sim adapter all the nodes share the metrics registry of the
+// current process, so the LES metrics are recorded only once per sampling
+// in the series named `ProcessSeries` instead of the per-node series.
+type Collector struct {
+	config *Config
+	nodes  []*node
+	shared bool // Whether the go-ethereum metrics are process-wide
+
+	lock   sync.RWMutex
+	series map[string]*Series
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// NewCollector creates the collector for all the nodes in the given cluster.
+func NewCollector(cluster *simulator.Cluster, config *Config) *Collector {
+	cfg := Config{Interval: time.Second, Prefixes: DefaultPrefixes}
+	if config != nil {
+		if config.Interval > 0 {
+			cfg.Interval = config.Interval
+		}
+		if len(config.Prefixes) > 0 {
+			cfg.Prefixes = config.Prefixes
+		}
+	}
+	c := &Collector{
+		config: &cfg,
+		shared: cluster.Adapter() == "sim",
+		series: make(map[string]*Series),
+	}
+	for _, server := range cluster.Servers() {
+		c.nodes = append(c.nodes, &node{name: server.Name(), role: "server", index: server.Index(), node: server.Node()})
+	}
+	for _, client := range cluster.Clients() {
+		c.nodes = append(c.nodes, &node{name: client.Name(), role: "client", index: client.Index(), node: client.Node()})
+	}
+	for _, n := range c.nodes {
+		c.series[n.name] = &Series{Node: n.name, Role: n.role}
+	}
+	if c.shared {
+		c.series[ProcessSeries] = &Series{Node: ProcessSeries, Role: ProcessSeries}
+	}
+	return c
+}
+
+// Start starts the background sampling.
+func (c *Collector) Start() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.quit != nil {
+		return
+	}
+	c.quit = make(chan struct{})
+	c.wg.Add(1)
+	go c.loop(c.quit)
+}
+
+// Stop stops the background sampling. The collected samples are still
+// available after stopping.
+func (c *Collector) Stop() {
+	c.lock.Lock()
+	if c.quit == nil {
+		c.lock.Unlock()
+		return
+	}
+	close(c.quit)
+	c.quit = nil
+	c.lock.Unlock()
+
+	c.wg.Wait()
+}
+
+func (c *Collector) loop(quit chan struct{}) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		c.Collect()
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// Collect samples all the nodes once. The node which is not running or fails
+// to answer is skipped.
+func (c *Collector) Collect() {
+	now := time.Now()
+	for _, n := range c.nodes {
+		values, err := c.sample(n)
+		if err != nil {
+			log.Debug("Failed to collect metrics", "node", n.name, "err", err)
+			continue
+		}
+		c.record(n.name, now, values)
+	}
+	if c.shared {
+		if values := c.processMetrics(); values != nil {
+			c.record(ProcessSeries, now, values)
+		}
+	}
+}
+
+// record appends the sample into the series with the given name.
+func (c *Collector) record(name string, now time.Time, values map[string]float64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	series := c.series[name]
+	series.Samples = append(series.Samples, &Sample{Time: now, Values: values})
+}
+
+// processMetrics retrieves the process-wide metrics via any running node, nil
+// is returned if no node is available.
+func (c *Collector) processMetrics() map[string]float64 {
+	for _, n := range c.nodes {
+		if !n.node.Up() {
+			continue
+		}
+		client, err := n.node.Client()
+		if err != nil {
+			continue
+		}
+		values := make(map[string]float64)
+		c.metrics(client, values)
+		return values
+	}
+	return nil
+}
+
+// sample retrieves the metric values of the given node.
+func (c *Collector) sample(n *node) (map[string]float64, error) {
+	if !n.node.Up() {
+		return nil, errNodeDown
+	}
+	client, err := n.node.Client()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]float64)
+
+	var peers hexutil.Uint
+	if err := client.Call(&peers, "net_peerCount"); err != nil {
+		return nil, err
+	}
+	values[PeerCount] = float64(peers)
+
+	var head hexutil.Uint64
+	if err := client.Call(&head, "eth_blockNumber"); err != nil {
+		return nil, err
+	}
+	values[HeadNumber] = float64(head)
+
+	// The process-wide metrics don't belong to the node, they are sampled
+	// separately.
+	if !c.shared {
+		c.metrics(client, values)
+	}
+	return values, nil
+}
+
+// metrics retrieves the go-ethereum metrics matching the configured prefixes
+// via the given client. The metrics are optional, they are unavailable if the
+// metrics system is disabled in the node.
+func (c *Collector) metrics(client *rpc.Client, values map[string]float64) {
+	var raw map[string]interface{}
+	if err := client.Call(&raw, "debug_metrics", true); err != nil {
+		return
+	}
+	for name, value := range flatten(raw) {
+		if hasPrefix(name, c.config.Prefixes) {
+			values[name] = value
+		}
+	}
+}
+
+// Series returns a copy of all the collected time series, keyed by node name.
+func (c *Collector) Series() map[string]*Series {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := make(map[string]*Series)
+	for name, series := range c.series {
+		result[name] = &Series{
+			Node:    series.Node,
+			Role:    series.Role,
+			Samples: append([]*Sample(nil), series.Samples...),
+		}
+	}
+	return result
+}
+
+// sortedSeries returns the copy of all the time series sorted by node name.
+func (c *Collector) sortedSeries() []*Series {
+	var list []*Series
+	for _, series := range c.Series() {
+		list = append(list, series)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Node < list[j].Node })
+	return list
+}
+
+// flatten converts the nested metrics returned by `debug_metrics` into the flat
+// map, the name of the value is the slash joined path, e.g.
+// les/misc/serve/header/Percentiles/50. The non-numeric values are ignored.
+func flatten(raw map[string]interface{}) map[string]float64 {
+	result := make(map[string]float64)
+
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for key, child := range v {
+				walk(prefix+"/"+key, child)
+			}
+		case float64:
+			result[strings.TrimPrefix(prefix, "/")] = v
+		}
+	}
+	walk("", raw)
+	return result
+}
+
+// hasPrefix returns true if the name matches any given prefix.
+func hasPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+func TestFlatten(t *testing.T) {
+	raw := map[string]interface{}{
+		"les": map[string]interface{}{
+			"misc": map[string]interface{}{
+				"serve": map[string]interface{}{
+					"header": map[string]interface{}{
+						"Overall":     float64(10),
+						"Percentiles": map[string]interface{}{"50": float64(3)},
+					},
+				},
+			},
+		},
+		"system": "Unknown metric type",
+	}
+	expected := map[string]float64{
+		"les/misc/serve/header/Overall":        10,
+		"les/misc/serve/header/Percentiles/50": 3,
+	}
+	if got := flatten(raw); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Unexpected flattened metrics, want %v, got %v", expected, got)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := &Collector{series: map[string]*Series{
+		"c0": {Node: "c0", Role: "client", Samples: []*Sample{
+			{Time: now, Values: map[string]float64{HeadNumber: 10, PeerCount: 1}},
+			{Time: now.Add(time.Second), Values: map[string]float64{HeadNumber: 14, PeerCount: 3}},
+		}},
+	}}
+	stats := c.Summary()
+	if len(stats) != 2 {
+		t.Fatalf("Unexpected stats number, want 2, got %d", len(stats))
+	}
+	head := stats[0]
+	if head.Metric != HeadNumber || head.First != 10 || head.Last != 14 || head.Min != 10 || head.Max != 14 || head.Mean != 12 {
+		t.Fatalf("Unexpected head stat %+v", head)
+	}
+	var buf bytes.Buffer
+	if err := c.ExportCSV(&buf); err != nil {
+		t.Fatalf("Failed to export csv, err: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 5 || lines[1] != "1000000,c0,client,head,10" {
+		t.Fatalf("Unexpected csv output %q", buf.String())
+	}
+}
+
+func TestPrometheusName(t *testing.T) {
+	var cases = []struct {
+		name     string
+		expected string
+	}{
+		{"les/misc/in/packets/header/Overall", "les_misc_in_packets_header_overall"},
+		{"les/misc/serve/header/Percentiles/50", "les_misc_serve_header_percentiles_50"},
+		{PeerCount, "les_node_peers"},
+		{HeadNumber, "les_node_head"},
+	}
+	for _, c := range cases {
+		if got := prometheusName(c.name); got != c.expected {
+			t.Fatalf("Unexpected prometheus name, want %s, got %s", c.expected, got)
+		}
+	}
+}
+
+func TestPrometheusType(t *testing.T) {
+	var cases = []struct {
+		name     string
+		expected string
+	}{
+		{"les/misc/in/packets/header/Overall", "counter"},
+		{"les/misc/serve/header/Percentiles/50", "gauge"},
+		{"les/misc/in/packets/header/AvgRate01Min", "gauge"},
+		{PeerCount, "gauge"},
+	}
+	for _, c := range cases {
+		if got := prometheusType(c.name); got != c.expected {
+			t.Fatalf("Unexpected prometheus type of %s, want %s, got %s", c.name, c.expected, got)
+		}
+	}
+}
+
+func TestProcessMetrics(t *testing.T) {
+	cluster, err := simulator.NewCluster(&simulator.ClusterConfig{
+		Adapter:        "sim",
+		ChainID:        1337,
+		Blocks:         4,
+		MetricsEnabled: true,
+		ServerConfig:   []*simulator.ServerServiceConfig{{LightServ: 100, LightPeers: 10, LogVerbosity: log.LvlError}},
+		ClientConfig:   []*simulator.ClientServiceConfig{{LogVerbosity: log.LvlError}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cluster: %v", err)
+	}
+	defer cluster.Close()
+	if err := cluster.StartNodes(); err != nil {
+		t.Fatalf("Failed to start cluster: %v", err)
+	}
+	c := NewCollector(cluster, &Config{Prefixes: []string{""}})
+	c.Collect()
+
+	series := c.Series()
+	if len(series) != 3 {
+		t.Fatalf("Unexpected series number, want 3, got %d", len(series))
+	}
+	for _, name := range []string{"s0", "c0"} {
+		samples := series[name].Samples
+		if len(samples) != 1 {
+			t.Fatalf("Unexpected sample number of %s, want 1, got %d", name, len(samples))
+		}
+		for metric := range samples[0].Values {
+			if metric != PeerCount && metric != HeadNumber {
+				t.Fatalf("Unexpected process-wide metric %s in the series of %s", metric, name)
+			}
+		}
+	}
+	// The values can be empty, the metrics are optional
+	process := series[ProcessSeries]
+	if process.Role != ProcessSeries || len(process.Samples) != 1 {
+		t.Fatalf("Unexpected process-wide series %+v", process)
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// ExportJSON writes all the collected time series into the writer in JSON
+// format, the series are sorted by node name.
+func (c *Collector) ExportJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(c.sortedSeries())
+}
+
+// ExportCSV writes all the collected time series into the writer in CSV
+// format. Each row is a single value with the columns:
+// time(unix milliseconds), node, role, metric, value.
+func (c *Collector) ExportCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"time", "node", "role", "metric", "value"}); err != nil {
+		return err
+	}
+	for _, series := range c.sortedSeries() {
+		for _, sample := range series.Samples {
+			ts := strconv.FormatInt(sample.Time.UnixNano()/int64(time.Millisecond), 10)
+			for _, name := range sortedKeys(sample.Values) {
+				value := strconv.FormatFloat(sample.Values[name], 'f', -1, 64)
+				if err := writer.Write([]string{ts, series.Node, series.Role, name, value}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Stat is the statistics of a single metric of a node over the whole run.
+type Stat struct {
+	Node   string
+	Role   string
+	Metric string
+	Count  int // Number of samples
+	First  float64
+	Last   float64
+	Min    float64
+	Max    float64
+	Mean   float64
+}
+
+// Summary returns the statistics of all the collected metrics, sorted by node
+// name and metric name.
+func (c *Collector) Summary() []*Stat {
+	var stats []*Stat
+	for _, series := range c.sortedSeries() {
+		index := make(map[string]*Stat)
+		for _, sample := range series.Samples {
+			for name, value := range sample.Values {
+				stat, ok := index[name]
+				if !ok {
+					stat = &Stat{Node: series.Node, Role: series.Role, Metric: name, First: value, Min: math.Inf(1), Max: math.Inf(-1)}
+					index[name] = stat
+				}
+				stat.Count++
+				stat.Last = value
+				stat.Min = math.Min(stat.Min, value)
+				stat.Max = math.Max(stat.Max, value)
+				stat.Mean += (value - stat.Mean) / float64(stat.Count)
+			}
+		}
+		var names []string
+		for name := range index {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			stats = append(stats, index[name])
+		}
+	}
+	return stats
+}
+
+// WriteSummary writes the statistics of all the collected metrics into the
+// writer as a human readable table.
+func (c *Collector) WriteSummary(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tROLE\tMETRIC\tSAMPLES\tFIRST\tLAST\tMIN\tMAX\tMEAN")
+	for _, stat := range c.Summary() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%g\t%g\t%g\t%g\t%.3f\n", stat.Node, stat.Role, stat.Metric, stat.Count, stat.First, stat.Last, stat.Min, stat.Max, stat.Mean)
+	}
+	return tw.Flush()
+}
+
+// sortedKeys returns the sorted metric names of the given values.
+func sortedKeys(values map[string]float64) []string {
+	var keys []string
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Handler returns the http handler which serves the metrics of all the nodes
+// in the Prometheus text format. The nodes are sampled when the handler is
+// requested, the samples are not recorded in the time series.
+//
+// The peer count and head number are labelled with the role, index, name and
+// ID of the node. So are the LES metrics of the exec adapter nodes, which are
+// only available if the metrics system is enabled, see
+// `ClusterConfig.MetricsEnabled`. The LES metrics of the sim adapter nodes are
+// process-wide, they are only labelled with the role "process" and can't be
+// told apart per node.
+func (c *Collector) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(c.prometheus())
+	})
+}
+
+// prometheus samples all the nodes and renders the values in the Prometheus
+// text format.
+func (c *Collector) prometheus() []byte {
+	var (
+		families = make(map[string][]string)
+		types    = make(map[string]string)
+	)
+	for _, n := range c.nodes {
+		values, err := c.sample(n)
+		if err != nil {
+			continue
+		}
+		labels := fmt.Sprintf(`{role=%q,index="%d",node=%q,id=%q}`, n.role, n.index, n.name, n.node.ID().String())
+		for name, value := range values {
+			metric := prometheusName(name)
+			families[metric] = append(families[metric], metric+labels+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			types[metric] = prometheusType(name)
+		}
+	}
+	if c.shared {
+		labels := fmt.Sprintf(`{role=%q}`, ProcessSeries)
+		for name, value := range c.processMetrics() {
+			metric := prometheusName(name)
+			families[metric] = append(families[metric], metric+labels+" "+strconv.FormatFloat(value, 'g', -1, 64))
+			types[metric] = prometheusType(name)
+		}
+	}
+	var names []string
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// All the values of the same metric must be grouped together
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "# TYPE %s %s\n", name, types[name])
+		lines := families[name]
+		sort.Strings(lines)
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// prometheusType returns the Prometheus metric type of the given metric. The
+// overall count of the meters and timers only grows, it's a counter. All the
+// others, e.g. the rates, percentiles and node level metrics, are gauges.
+func prometheusType(name string) string {
+	if strings.HasSuffix(name, "/Overall") {
+		return "counter"
+	}
+	return "gauge"
+}
+
+// prometheusName converts the metric name into the valid Prometheus metric
+// name, e.g. les/misc/in/packets/header/Overall is converted to
+// les_misc_in_packets_header_overall. The node level metrics are prefixed with
+// les_node_.
+func prometheusName(name string) string {
+	if !strings.HasPrefix(name, "les/") {
+		name = "les_node_" + name
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, name)
+}
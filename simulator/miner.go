@@ -0,0 +1,292 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// pendingRetryInterval is the time between two checks whether the pending
+// block of the miner is built on top of the current head.
+const pendingRetryInterval = 10 * time.Millisecond
+
+var (
+	errProducerStopped = errors.New("block producer stopped")
+	errNoMiner         = errors.New("no mining server")
+)
+
+// blockProducer is the life cycle which drives the mining of the server. With
+// the fake PoW the blocks are sealed as soon as the mining work is committed,
+// so the block interval is enforced by sealing a single block on each tick.
+//
+// The mining can be paused and resumed, and the blocks can be mined manually
+// via the "sim" RPC namespace regardless of whether the mining is paused.
+type blockProducer struct {
+	eth      *eth.Ethereum
+	interval time.Duration // Interval between blocks, 0 means mining continuously
+	manual   bool          // Whether the automatic mining is paused initially
+
+	mineCh  chan *mineRequest
+	pauseCh chan bool
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// mineRequest is the request for mining the given number of blocks manually.
+type mineRequest struct {
+	blocks int
+	result chan error
+}
+
+func newBlockProducer(stack *node.Node, eth *eth.Ethereum, interval time.Duration, manual bool) *blockProducer {
+	p := &blockProducer{
+		eth:      eth,
+		interval: interval,
+		manual:   manual,
+		mineCh:   make(chan *mineRequest),
+		pauseCh:  make(chan bool),
+		quit:     make(chan struct{}),
+	}
+	stack.RegisterAPIs([]rpc.API{{
+		Namespace: "sim",
+		Version:   "1.0",
+		Service:   &minerAPI{p: p},
+		Public:    true,
+	}})
+	return p
+}
+
+// Start implements node.Lifecycle, starting the mining.
+func (p *blockProducer) Start() error {
+	p.eth.Miner().DisablePreseal()
+	p.wg.Add(1)
+	go p.loop()
+	return nil
+}
+
+// Stop implements node.Lifecycle, terminating the mining.
+func (p *blockProducer) Stop() error {
+	close(p.quit)
+	p.wg.Wait()
+	p.eth.StopMining()
+	return nil
+}
+
+func (p *blockProducer) loop() {
+	defer p.wg.Done()
+
+	var (
+		paused = p.manual
+		tick   <-chan time.Time
+	)
+	if p.interval > 0 {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	} else if !paused {
+		p.mineContinuously(true)
+	}
+	for {
+		select {
+		case <-tick:
+			if !paused {
+				if err := p.mineBlock(); err != nil {
+					log.Warn("Failed to mine block", "err", err)
+				}
+			}
+
+		case req := <-p.mineCh:
+			// Suspend the continuous mining so that exactly the requested
+			// number of blocks are mined.
+			if p.interval == 0 && !paused {
+				p.mineContinuously(false)
+			}
+			var err error
+			for i := 0; i < req.blocks && err == nil; i++ {
+				err = p.mineBlock()
+			}
+			if p.interval == 0 && !paused {
+				p.mineContinuously(true)
+			}
+			req.result <- err
+
+		case pause := <-p.pauseCh:
+			if pause != paused && p.interval == 0 {
+				p.mineContinuously(!pause)
+			}
+			paused = pause
+
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// mineContinuously starts or stops the continuous mining.
+func (p *blockProducer) mineContinuously(start bool) {
+	if !start {
+		p.eth.StopMining()
+		return
+	}
+	if err := p.eth.StartMining(1); err != nil {
+		log.Warn("Failed to start mining", "err", err)
+	}
+}
+
+// mineBlock seals a single block on top of the local head, inserts it and
+// broadcasts it to the connected servers. The block is the pending block
+// assembled by the miner with the pending transactions, sealing it directly
+// rather than starting the miner ensures exactly one block is produced, which
+// is never the case with the fake PoW.
+//
+// Note the miner doesn't set the etherbase of the pending block if it's not
+// running, so the block reward goes to the zero address.
+func (p *blockProducer) mineBlock() error {
+	var (
+		chain = p.eth.BlockChain()
+		block *types.Block
+	)
+	// The pending block is updated asynchronously after the head is changed,
+	// wait until it's built on top of the current head.
+	for {
+		head := chain.CurrentBlock()
+		if pending := p.eth.Miner().PendingBlock(); pending != nil && pending.ParentHash() == head.Hash() {
+			block = pending
+			break
+		}
+		select {
+		case <-time.After(pendingRetryInterval):
+		case <-p.quit:
+			return errProducerStopped
+		}
+	}
+	results := make(chan *types.Block, 1)
+	if err := p.eth.Engine().Seal(chain, block, results, p.quit); err != nil {
+		return err
+	}
+	select {
+	case sealed := <-results:
+		if _, err := chain.InsertChain(types.Blocks{sealed}); err != nil {
+			return err
+		}
+		// Broadcast the block to the other servers, the eth handler only
+		// propagates the mined blocks announced via the event mux.
+		return p.eth.EventMux().Post(core.NewMinedBlockEvent{Block: sealed})
+	case <-p.quit:
+		return errProducerStopped
+	}
+}
+
+// minerAPI is the RPC API for controlling the block producer.
+type minerAPI struct {
+	p *blockProducer
+}
+
+// MineBlocks mines the given number of blocks and returns once they are all
+// inserted into the local chain.
+func (api *minerAPI) MineBlocks(ctx context.Context, blocks int) error {
+	req := &mineRequest{blocks: blocks, result: make(chan error, 1)}
+	select {
+	case api.p.mineCh <- req:
+	case <-api.p.quit:
+		return errProducerStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// PauseMining stops the automatic mining, the blocks can still be mined by
+// MineBlocks.
+func (api *minerAPI) PauseMining() error {
+	return api.setPaused(true)
+}
+
+// ResumeMining restarts the automatic mining.
+func (api *minerAPI) ResumeMining() error {
+	return api.setPaused(false)
+}
+
+func (api *minerAPI) setPaused(paused bool) error {
+	select {
+	case api.p.pauseCh <- paused:
+		return nil
+	case <-api.p.quit:
+		return errProducerStopped
+	}
+}
+
+// MineBlocks mines the given number of blocks on the first miner and waits
+// until they are inserted into its chain. It works even if the mining is
+// paused, so that the chain can be advanced step by step.
+func (cluster *Cluster) MineBlocks(n int) error {
+	miners := cluster.Miners()
+	if len(miners) == 0 {
+		return errNoMiner
+	}
+	return cluster.callMiner(miners[0], "sim_mineBlocks", n)
+}
+
+// PauseMining pauses the automatic mining of all the miners.
+func (cluster *Cluster) PauseMining() error {
+	return cluster.callMiners("sim_pauseMining")
+}
+
+// ResumeMining resumes the automatic mining of all the miners.
+func (cluster *Cluster) ResumeMining() error {
+	return cluster.callMiners("sim_resumeMining")
+}
+
+// Miners returns the indexes of the mining servers in ascending order.
+func (cluster *Cluster) Miners() []int {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	var miners []int
+	for index := range cluster.miners {
+		miners = append(miners, index)
+	}
+	sort.Ints(miners)
+	return miners
+}
+
+func (cluster *Cluster) callMiners(method string) error {
+	miners := cluster.Miners()
+	if len(miners) == 0 {
+		return errNoMiner
+	}
+	var errs MultiError
+	for _, index := range miners {
+		if err := cluster.callMiner(index, method); err != nil {
+			errs = append(errs, &NodeError{Node: serverName(index), Err: err})
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func (cluster *Cluster) callMiner(index int, method string, args ...interface{}) error {
+	cluster.lock.RLock()
+	server := cluster.servers[index]
+	cluster.lock.RUnlock()
+
+	client, err := server.node.Client()
+	if err != nil {
+		return fmt.Errorf("%s: %v", server.Name(), err)
+	}
+	return client.Call(nil, method, args...)
+}
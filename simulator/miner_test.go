@@ -0,0 +1,130 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// newMiningCluster creates the cluster with the given servers and a client
+// and waits until all the nodes are connected, so that no mined block is
+// missed. All the servers serve the client even if they are not synced.
+func newMiningCluster(t *testing.T, servers int, adjust func(config *ClusterConfig)) *Cluster {
+	cluster := newTestCluster(t, servers, 1, func(config *ClusterConfig) {
+		for _, server := range config.ServerConfig {
+			server.LightNoSyncServe = true
+		}
+		adjust(config)
+	})
+	if err := cluster.Connect(); err != nil {
+		cluster.Close()
+		t.Fatalf("Failed to connect cluster: %v", err)
+	}
+	for _, server := range cluster.Servers() {
+		waitPeers(t, cluster, server.Name(), server.Node(), servers)
+	}
+	client := cluster.Clients()[0]
+	waitPeers(t, cluster, client.Name(), client.Node(), servers)
+	return cluster
+}
+
+// waitPeers waits until the given node is connected to the number of peers.
+func waitPeers(t *testing.T, cluster *Cluster, name string, node *simulations.Node, number int) {
+	t.Helper()
+
+	client, err := node.Client()
+	if err != nil {
+		cluster.Close()
+		t.Fatalf("Failed to connect %s: %v", name, err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var peers hexutil.Uint
+		if err := client.Call(&peers, "net_peerCount"); err != nil {
+			cluster.Close()
+			t.Fatalf("Failed to retrieve peer count of %s: %v", name, err)
+		}
+		if int(peers) == number {
+			return
+		}
+		if time.Now().After(deadline) {
+			cluster.Close()
+			t.Fatalf("Unexpected peer count of %s, want %d, got %d", name, number, peers)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// waitHead waits until the head of the given node reaches the number and
+// returns the final head number.
+func waitHead(t *testing.T, name string, node *simulations.Node, number uint64) uint64 {
+	t.Helper()
+
+	client, err := node.Client()
+	if err != nil {
+		t.Fatalf("Failed to connect %s: %v", name, err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var head hexutil.Uint64
+		if err := client.Call(&head, "eth_blockNumber"); err != nil {
+			t.Fatalf("Failed to retrieve head of %s: %v", name, err)
+		}
+		if uint64(head) >= number || time.Now().After(deadline) {
+			return uint64(head)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestMineBlocks(t *testing.T) {
+	cluster := newMiningCluster(t, 2, func(config *ClusterConfig) {
+		config.ManualMining = true
+	})
+	defer cluster.Close()
+
+	var (
+		miner  = cluster.Servers()[0]
+		server = cluster.Servers()[1]
+		client = cluster.Clients()[0]
+	)
+	want := waitHead(t, miner.Name(), miner.Node(), 0)
+	if want != 4 {
+		t.Fatalf("Unexpected initial head, want 4, got %d", want)
+	}
+	for _, n := range []int{1, 3, 5} {
+		if err := cluster.MineBlocks(n); err != nil {
+			t.Fatalf("Failed to mine %d blocks: %v", n, err)
+		}
+		want += uint64(n)
+		if got := waitHead(t, miner.Name(), miner.Node(), want); got != want {
+			t.Fatalf("Unexpected head after mining %d blocks, want %d, got %d", n, want, got)
+		}
+		// The mined blocks are propagated to the other server and the client
+		if got := waitHead(t, server.Name(), server.Node(), want); got != want {
+			t.Fatalf("Unexpected head of %s after mining %d blocks, want %d, got %d", server.Name(), n, want, got)
+		}
+		if got := waitHead(t, client.Name(), client.Node(), want); got != want {
+			t.Fatalf("Unexpected head of %s after mining %d blocks, want %d, got %d", client.Name(), n, want, got)
+		}
+	}
+}
+
+func TestBlockInterval(t *testing.T) {
+	cluster := newMiningCluster(t, 3, func(config *ClusterConfig) {
+		config.Miners = []int{0, 1}
+		config.BlockInterval = 100 * time.Millisecond
+	})
+	defer cluster.Close()
+
+	// The blocks of both miners reach the non-mining server and the client
+	server, client := cluster.Servers()[2], cluster.Clients()[0]
+	if got := waitHead(t, server.Name(), server.Node(), 8); got < 8 {
+		t.Fatalf("Unexpected head of %s, want at least 8, got %d", server.Name(), got)
+	}
+	if got := waitHead(t, client.Name(), client.Node(), 8); got < 8 {
+		t.Fatalf("Unexpected head of %s, want at least 8, got %d", client.Name(), got)
+	}
+}
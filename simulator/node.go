@@ -0,0 +1,120 @@
+package simulator
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// defaultReadyTimeout is the default maximum time for waiting a node to be ready.
+const defaultReadyTimeout = 30 * time.Second
+
+// serverName returns the name of the server with the given index, the name
+// is the same as the one used in the topology string.
+func serverName(index int) string {
+	return fmt.Sprintf("s%d", index)
+}
+
+// clientName returns the name of the client with the given index, the name
+// is the same as the one used in the topology string.
+func clientName(index int) string {
+	return fmt.Sprintf("c%d", index)
+}
+
+// NodeLess reports whether the node name a sorts before b. The servers come
+// before the clients and the nodes with the same role are ordered by index,
+// so that s2 comes before s10. The other names are ordered lexically after
+// them.
+func NodeLess(a, b string) bool {
+	ra, ia := nodeOrder(a)
+	rb, ib := nodeOrder(b)
+	if ra != rb {
+		return ra < rb
+	}
+	if ia != ib {
+		return ia < ib
+	}
+	return a < b
+}
+
+// nodeOrder returns the rank of the node role and the node index parsed from
+// the node name, the unknown name has the lowest rank.
+func nodeOrder(name string) (int, int) {
+	if len(name) < 2 {
+		return 2, 0
+	}
+	index, err := strconv.Atoi(name[1:])
+	if err != nil || index < 0 {
+		return 2, 0
+	}
+	switch name[0] {
+	case 's':
+		return 0, index
+	case 'c':
+		return 1, index
+	}
+	return 2, 0
+}
+
+// NodeError is the failure happened on the specific node.
+type NodeError struct {
+	Node string // Name of the node, e.g. s0 or c1
+	Err  error
+}
+
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Node, e.Err)
+}
+
+// MultiError is a collection of failures happened on the different nodes.
+type MultiError []*NodeError
+
+func (m MultiError) Error() string {
+	var msgs []string
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d node failure(s): %s", len(m), strings.Join(msgs, "; "))
+}
+
+// ErrorOrNil returns nil if there is no failure, otherwise the error itself.
+func (m MultiError) ErrorOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
+
+// sort sorts the failures by node name so that the error message is stable.
+func (m MultiError) sort() {
+	sort.Slice(m, func(i, j int) bool { return NodeLess(m[i].Node, m[j].Node) })
+}
+
+// waitNodeReady waits until the RPC of the given node answers and the LES
+// protocol is registered, or the timeout is reached.
+func waitNodeReady(node *simulations.Node, timeout time.Duration) error {
+	var (
+		deadline = time.Now().Add(timeout)
+		lastErr  error
+	)
+	for time.Now().Before(deadline) {
+		client, err := node.Client()
+		if err == nil {
+			var info p2p.NodeInfo
+			if err = client.Call(&info, "admin_nodeInfo"); err == nil {
+				if _, ok := info.Protocols["les"]; ok {
+					return nil
+				}
+				err = fmt.Errorf("les protocol is not registered")
+			}
+		}
+		lastErr = err
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("node is not ready in %v: %v", timeout, lastErr)
+}
@@ -0,0 +1,38 @@
+package simulator
+
+import (
+	"errors"
+	"sort"
+	"testing"
+)
+
+func TestMultiError(t *testing.T) {
+	var errs MultiError
+	if errs.ErrorOrNil() != nil {
+		t.Fatalf("Empty multi-error should be nil")
+	}
+	errs = append(errs, &NodeError{Node: clientName(1), Err: errors.New("boom")})
+	errs = append(errs, &NodeError{Node: serverName(10), Err: errors.New("bang")})
+	errs = append(errs, &NodeError{Node: serverName(2), Err: errors.New("bang")})
+	errs.sort()
+
+	err := errs.ErrorOrNil()
+	if err == nil {
+		t.Fatalf("Failures are not reported")
+	}
+	if want := "3 node failure(s): s2: bang; s10: bang; c1: boom"; err.Error() != want {
+		t.Fatalf("Unexpected error message, want %q, got %q", want, err.Error())
+	}
+}
+
+func TestNodeLess(t *testing.T) {
+	names := []string{"c10", "x", "s10", "c2", "s1", "c0", "s2", "s0"}
+	sort.Slice(names, func(i, j int) bool { return NodeLess(names[i], names[j]) })
+
+	want := []string{"s0", "s1", "s2", "s10", "c0", "c2", "c10", "x"}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("Unexpected order, want %v, got %v", want, names)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package simulator
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/p2p/simulations"
+)
+
+// link is the connection between two nodes established by Connect.
+type link struct {
+	one, other *simulations.Node
+}
+
+// Link is the connection of the configured topology between two nodes.
+type Link struct {
+	One   string `json:"one"`   // Name of the node which dials, e.g. c1
+	Other string `json:"other"` // Name of the dialed node, e.g. s0
+	Up    bool   `json:"up"`    // Whether the nodes are connected currently
+}
+
+// OracleAddress returns the address of the checkpoint oracle contract, it's
+// empty if the contract isn't deployed.
+func (cluster *Cluster) OracleAddress() common.Address {
+	return cluster.oracleAddress
+}
+
+// LotteryAddress returns the address of the payment contract, it's empty if
+// the contract isn't deployed.
+func (cluster *Cluster) LotteryAddress() common.Address {
+	return cluster.lotteryAddress
+}
+
+// NodeByName returns the node with the given name, e.g. s0 or c1. Nil is
+// returned if there is no such node.
+func (cluster *Cluster) NodeByName(name string) *simulations.Node {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	for _, server := range cluster.servers {
+		if server.Name() == name {
+			return server.node
+		}
+	}
+	for _, client := range cluster.clients {
+		if client.Name() == name {
+			return client.node
+		}
+	}
+	return nil
+}
+
+// links returns all the connections established by Connect, which are
+// specified by the configured topology.
+func (cluster *Cluster) links() []link {
+	cluster.lock.RLock()
+	defer cluster.lock.RUnlock()
+
+	var links []link
+	if cluster.config.Conns == nil {
+		for _, client := range cluster.clients {
+			for _, server := range cluster.servers {
+				links = append(links, link{client.node, server.node})
+			}
+		}
+	} else {
+		for _, conn := range cluster.config.Conns {
+			if conn.From < len(cluster.clients) && conn.To < len(cluster.servers) {
+				links = append(links, link{cluster.clients[conn.From].node, cluster.servers[conn.To].node})
+			}
+		}
+		// The added clients aren't covered by the topology, they are
+		// connected to all the servers.
+		for _, client := range cluster.clients[len(cluster.config.ClientConfig):] {
+			for _, server := range cluster.servers {
+				links = append(links, link{client.node, server.node})
+			}
+		}
+	}
+	for i := range cluster.servers {
+		for j := i + 1; j < len(cluster.servers); j++ {
+			if !cluster.isolated(i) && !cluster.isolated(j) {
+				links = append(links, link{cluster.servers[i].node, cluster.servers[j].node})
+			}
+		}
+	}
+	return links
+}
+
+// Topology returns all the connections of the configured topology along with
+// their current state.
+func (cluster *Cluster) Topology() []*Link {
+	var links []*Link
+	for _, l := range cluster.links() {
+		links = append(links, &Link{
+			One:   cluster.nodeName(l.one.ID()),
+			Other: cluster.nodeName(l.other.ID()),
+			Up:    cluster.connected(l.one.ID(), l.other.ID()),
+		})
+	}
+	return links
+}
+
+// connected returns whether the two nodes are connected currently.
+func (cluster *Cluster) connected(one, other enode.ID) bool {
+	conn := cluster.network.GetConn(one, other)
+	return conn != nil && conn.Up
+}
+
+// partitionGroups maps each node name to the index of the group it belongs
+// to. The node can't belong to multiple groups.
+func partitionGroups(groups [][]string) (map[string]int, error) {
+	index := make(map[string]int)
+	for i, group := range groups {
+		for _, name := range group {
+			if _, ok := index[name]; ok {
+				return nil, fmt.Errorf("node %s in multiple groups", name)
+			}
+			index[name] = i
+		}
+	}
+	return index, nil
+}
+
+// Partition splits the network into the given groups of nodes by names, all
+// the connections between the nodes of different groups are dropped. The nodes
+// which are not in any group are left untouched.
+func (cluster *Cluster) Partition(groups [][]string) error {
+	index, err := partitionGroups(groups)
+	if err != nil {
+		return err
+	}
+	for name := range index {
+		if cluster.NodeByName(name) == nil {
+			return fmt.Errorf("unknown node %s", name)
+		}
+	}
+	var errs MultiError
+	for a, ga := range index {
+		for b, gb := range index {
+			if a >= b || ga == gb {
+				continue
+			}
+			one, other := cluster.NodeByName(a).ID(), cluster.NodeByName(b).ID()
+			if !cluster.connected(one, other) {
+				continue
+			}
+			if err := cluster.network.Disconnect(one, other); err != nil {
+				errs = append(errs, &NodeError{Node: a, Err: err})
+			}
+		}
+	}
+	errs.sort()
+	return errs.ErrorOrNil()
+}
+
+// Heal re-establishes all the connections of the configured topology which
+// are dropped, e.g. by Partition. The connections of the stopped nodes are
+// skipped.
+func (cluster *Cluster) Heal() error {
+	var errs MultiError
+	for _, l := range cluster.links() {
+		if err := cluster.reconnect(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs.sort()
+	return errs.ErrorOrNil()
+}
+
+// JoinNode adds the node with the given name back to the network, it's
+// started if it's stopped and connected to the running peers according to the
+// configured topology.
+//
+// The exec adapter can't run the nodes registered after the cluster is
+// created, the nodes to be added later should be stopped after the cluster is
+// started and joined when needed. With the sim adapter the new nodes can be
+// added by AddServer and AddClient instead.
+func (cluster *Cluster) JoinNode(name string) error {
+	node := cluster.NodeByName(name)
+	if node == nil {
+		return fmt.Errorf("unknown node %s", name)
+	}
+	if !node.Up() {
+		if err := cluster.network.Start(node.ID()); err != nil {
+			return &NodeError{Node: name, Err: err}
+		}
+		if err := waitNodeReady(node, defaultReadyTimeout); err != nil {
+			return &NodeError{Node: name, Err: err}
+		}
+	}
+	var errs MultiError
+	for _, l := range cluster.links() {
+		if l.one != node && l.other != node {
+			continue
+		}
+		if err := cluster.reconnect(l); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	errs.sort()
+	return errs.ErrorOrNil()
+}
+
+// reconnect establishes the connection if both nodes are running and they are
+// not connected yet.
+func (cluster *Cluster) reconnect(l link) *NodeError {
+	if !l.one.Up() || !l.other.Up() || cluster.connected(l.one.ID(), l.other.ID()) {
+		return nil
+	}
+	if err := cluster.network.Connect(l.one.ID(), l.other.ID()); err != nil {
+		return &NodeError{Node: cluster.nodeName(l.one.ID()), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package simulator
+
+import "testing"
+
+func TestPartitionGroups(t *testing.T) {
+	index, err := partitionGroups([][]string{{"s0", "c0"}, {"s1"}, {"c1", "c2"}})
+	if err != nil {
+		t.Fatalf("Failed to build partition groups: %v", err)
+	}
+	want := map[string]int{"s0": 0, "c0": 0, "s1": 1, "c1": 2, "c2": 2}
+	if len(index) != len(want) {
+		t.Fatalf("Unexpected node number, want %d, got %d", len(want), len(index))
+	}
+	for name, group := range want {
+		if got, ok := index[name]; !ok || got != group {
+			t.Fatalf("Unexpected group of %s, want %d, got %d", name, group, got)
+		}
+	}
+	if _, err := partitionGroups([][]string{{"s0"}, {"c0", "s0"}}); err == nil {
+		t.Fatal("Expected error for node in multiple groups")
+	}
+}
@@ -0,0 +1,112 @@
+package simulator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// pinnedServerProperty is the prefix of the node property which carries the
+// enode URL of the pinned server. The server references are resolved by the
+// cluster and passed via the node properties, since the node keys are only
+// known after the node configs are generated, and the properties are also
+// available in the exec adapter child process.
+const pinnedServerProperty = "pinned-server="
+
+// trustedServerProperty is the prefix of the node property which carries the
+// enode URL of the trusted ultra light server resolved by the cluster.
+const trustedServerProperty = "trusted-server="
+
+// propertyValues returns the values of all the node properties with the given
+// prefix.
+func propertyValues(properties []string, prefix string) []string {
+	var values []string
+	for _, property := range properties {
+		if strings.HasPrefix(property, prefix) {
+			values = append(values, strings.TrimPrefix(property, prefix))
+		}
+	}
+	return values
+}
+
+// parseServerRef parses the server reference in the topology style, e.g. s0
+// or S0, and returns the server index.
+func parseServerRef(ref string) (int, bool) {
+	if len(ref) < 2 || (ref[0] != 's' && ref[0] != 'S') {
+		return 0, false
+	}
+	index, err := strconv.Atoi(ref[1:])
+	if err != nil || index < 0 {
+		return 0, false
+	}
+	return index, true
+}
+
+// resolveTrustedServers returns the enode URLs of the trusted ultra light
+// servers which are referenced by index or in the topology style, the enode
+// URLs configured directly are not included.
+func resolveTrustedServers(cfg *ClientServiceConfig, servers []*enode.Node) ([]string, error) {
+	indexes := append([]int(nil), cfg.TrustedServerIndexes...)
+	for _, server := range cfg.TrustedServers {
+		if index, ok := parseServerRef(server); ok {
+			indexes = append(indexes, index)
+		}
+	}
+	var urls []string
+	for _, index := range indexes {
+		if index < 0 || index >= len(servers) {
+			return nil, fmt.Errorf("invalid trusted server index %d", index)
+		}
+		urls = append(urls, servers[index].URLv4())
+	}
+	return urls, nil
+}
+
+// trustedServerURLs returns the enode URLs of the trusted ultra light servers
+// for the client service. The server references are dropped from the configured
+// list since they are resolved by the cluster and passed via the properties.
+func trustedServerURLs(configured []string, properties []string) []string {
+	var urls []string
+	for _, server := range configured {
+		if _, ok := parseServerRef(server); !ok {
+			urls = append(urls, server)
+		}
+	}
+	return append(urls, propertyValues(properties, trustedServerProperty)...)
+}
+
+// serverPinner is the life cycle which keeps the client connected with the
+// pinned servers. The servers are added as the trusted and static peers, so
+// they are reconnected if dropped and are not limited by the max peers.
+type serverPinner struct {
+	stack   *node.Node
+	servers []*enode.Node
+}
+
+func newServerPinner(stack *node.Node, urls []string) (*serverPinner, error) {
+	p := &serverPinner{stack: stack}
+	for _, url := range urls {
+		server, err := enode.ParseV4(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pinned server %s: %v", url, err)
+		}
+		p.servers = append(p.servers, server)
+	}
+	return p, nil
+}
+
+// Start implements node.Lifecycle, connecting the pinned servers.
+func (p *serverPinner) Start() error {
+	srv := p.stack.Server()
+	for _, server := range p.servers {
+		srv.AddTrustedPeer(server)
+		srv.AddPeer(server)
+	}
+	return nil
+}
+
+// Stop implements node.Lifecycle, it's a noop.
+func (p *serverPinner) Stop() error { return nil }
@@ -0,0 +1,55 @@
+package simulator
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+func TestPropertyValues(t *testing.T) {
+	properties := []string{"client", pinnedServerProperty + "enode://a", "other=b", pinnedServerProperty + "enode://c"}
+	got := propertyValues(properties, pinnedServerProperty)
+	want := []string{"enode://a", "enode://c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Unexpected property values, want %v, got %v", want, got)
+	}
+	if values := propertyValues(properties, "missing="); values != nil {
+		t.Fatalf("Unexpected values %v", values)
+	}
+}
+
+func TestResolveTrustedServers(t *testing.T) {
+	var servers []*enode.Node
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		servers = append(servers, enode.NewV4(&key.PublicKey, net.IP{127, 0, 0, 1}, 30303+i, 30303+i))
+	}
+	external := "enode://external"
+	cfg := &ClientServiceConfig{
+		TrustedServers:       []string{external, "s2", "S0"},
+		TrustedServerIndexes: []int{1},
+	}
+	resolved, err := resolveTrustedServers(cfg, servers)
+	if err != nil {
+		t.Fatalf("Failed to resolve trusted servers: %v", err)
+	}
+	want := []string{servers[1].URLv4(), servers[2].URLv4(), servers[0].URLv4()}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Fatalf("Unexpected resolved servers, want %v, got %v", want, resolved)
+	}
+	var properties []string
+	for _, url := range resolved {
+		properties = append(properties, trustedServerProperty+url)
+	}
+	urls := trustedServerURLs(cfg.TrustedServers, properties)
+	if !reflect.DeepEqual(urls, append([]string{external}, want...)) {
+		t.Fatalf("Unexpected trusted servers %v", urls)
+	}
+	cfg.TrustedServers = []string{"s3"}
+	if _, err := resolveTrustedServers(cfg, servers); err == nil {
+		t.Fatalf("Expected error for invalid server reference")
+	}
+}
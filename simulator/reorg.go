@@ -0,0 +1,215 @@
+package simulator
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+// forkCoinbase is the etherbase of the injected fork blocks, which makes them
+// differ from the canonical ones at the same height.
+var forkCoinbase = common.HexToAddress("0xf0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0f0")
+
+// maxForkExtension is the maximum number of blocks appended to the fork beyond
+// the replaced depth for making it heavier than the canonical chain.
+const maxForkExtension = 16
+
+// forkDatabase is the database for generating the fork. The writes are kept
+// in memory and the reads fall back to the chain of the node, so that the
+// fork state never reaches the chain database.
+type forkDatabase struct {
+	ethdb.Database                // Chain database of the node, only read
+	mem            ethdb.Database // In-memory database holding the fork state
+	triedb         *trie.Database // State cache of the chain, holding the unflushed trie nodes
+}
+
+func newForkDatabase(chain *core.BlockChain, db ethdb.Database) *forkDatabase {
+	return &forkDatabase{
+		Database: db,
+		mem:      rawdb.NewMemoryDatabase(),
+		triedb:   chain.StateCache().TrieDB(),
+	}
+}
+
+// Has implements ethdb.KeyValueReader.
+func (db *forkDatabase) Has(key []byte) (bool, error) {
+	if _, err := db.Get(key); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// Get implements ethdb.KeyValueReader. The hash keyed entries are resolved via
+// the state cache of the chain, which falls back to the chain database.
+func (db *forkDatabase) Get(key []byte) ([]byte, error) {
+	if blob, err := db.mem.Get(key); err == nil {
+		return blob, nil
+	}
+	if len(key) == common.HashLength {
+		return db.triedb.Node(common.BytesToHash(key))
+	}
+	return db.Database.Get(key)
+}
+
+// Put implements ethdb.KeyValueWriter, the entry is only written in memory.
+func (db *forkDatabase) Put(key []byte, value []byte) error {
+	return db.mem.Put(key, value)
+}
+
+// Delete implements ethdb.KeyValueWriter, the entry is only deleted in memory.
+func (db *forkDatabase) Delete(key []byte) error {
+	return db.mem.Delete(key)
+}
+
+// NewBatch implements ethdb.Batcher, the batch is only written in memory.
+func (db *forkDatabase) NewBatch() ethdb.Batch {
+	return db.mem.NewBatch()
+}
+
+// Close implements io.Closer, it releases the in-memory database but leaves
+// the chain database open.
+func (db *forkDatabase) Close() error {
+	return db.mem.Close()
+}
+
+// chainAPI is the RPC API of the server for manipulating the local chain.
+type chainAPI struct {
+	eth *eth.Ethereum
+}
+
+// ForkChain generates a fork which replaces the last depth canonical blocks
+// and is heavier than the canonical chain. The fork blocks are returned in
+// RLP encoding but not inserted.
+//
+// The state of the common ancestor must still be available, which means the
+// depth can't exceed the number of the recent states kept in memory.
+func (api *chainAPI) ForkChain(depth uint64) ([]hexutil.Bytes, error) {
+	chain := api.eth.BlockChain()
+	head := chain.CurrentBlock()
+	if depth == 0 || depth > head.NumberU64() {
+		return nil, fmt.Errorf("invalid reorg depth %d, head %d", depth, head.NumberU64())
+	}
+	ancestor := chain.GetBlockByNumber(head.NumberU64() - depth)
+
+	// GenerateChain panics on the missing state, check it beforehand.
+	if _, err := chain.StateAt(ancestor.Root()); err != nil {
+		return nil, fmt.Errorf("ancestor state unavailable: %v", err)
+	}
+	var (
+		headTd = chain.GetTd(head.Hash(), head.NumberU64())
+		forkTd = chain.GetTd(ancestor.Hash(), ancestor.NumberU64())
+		blocks []*types.Block
+	)
+	for n := int(depth) + 1; n <= int(depth)+maxForkExtension; n++ {
+		// The fork is generated into a scratch database so that the chain
+		// database of the node is left untouched until the fork is inserted.
+		db := newForkDatabase(chain, api.eth.ChainDb())
+
+		// The fork blocks are mined 1 second apart for the highest difficulty.
+		blocks, _ = core.GenerateChain(chain.Config(), ancestor, api.eth.Engine(), db, n, func(i int, gen *core.BlockGen) {
+			gen.SetCoinbase(forkCoinbase)
+			gen.OffsetTime(-9)
+		})
+		db.Close()
+		td := new(big.Int).Set(forkTd)
+		for _, block := range blocks {
+			td.Add(td, block.Difficulty())
+		}
+		if td.Cmp(headTd) > 0 {
+			break
+		}
+		blocks = nil
+	}
+	if blocks == nil {
+		return nil, errors.New("failed to generate heavier fork")
+	}
+	var encoded []hexutil.Bytes
+	for _, block := range blocks {
+		blob, err := rlp.EncodeToBytes(block)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, blob)
+	}
+	return encoded, nil
+}
+
+// InsertChain inserts the given RLP encoded blocks into the local chain and
+// returns the new head hash.
+func (api *chainAPI) InsertChain(encoded []hexutil.Bytes) (common.Hash, error) {
+	var blocks types.Blocks
+	for _, blob := range encoded {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(blob, block); err != nil {
+			return common.Hash{}, err
+		}
+		blocks = append(blocks, block)
+	}
+	if n, err := api.eth.BlockChain().InsertChain(blocks); err != nil {
+		return common.Hash{}, fmt.Errorf("failed to insert block %d: %v", n, err)
+	}
+	return api.eth.BlockChain().CurrentBlock().Hash(), nil
+}
+
+// InjectReorg generates a heavier fork which replaces the last depth blocks
+// of the first given server and inserts it into all the given servers. The
+// servers then announce the new head to the connected clients. The nil server
+// list means all the servers. The head hash of the fork is returned.
+//
+// The error is returned if the fork doesn't become the head of any server,
+// e.g. the server mined further blocks meanwhile, so the mining is better
+// paused with `ClusterConfig.ManualMining`.
+func (cluster *Cluster) InjectReorg(depth uint64, onServers []int) (common.Hash, error) {
+	servers := cluster.Servers()
+	if onServers == nil {
+		for i := range servers {
+			onServers = append(onServers, i)
+		}
+	}
+	if len(onServers) == 0 {
+		return common.Hash{}, errors.New("no server to inject reorg")
+	}
+	for _, index := range onServers {
+		if index < 0 || index >= len(servers) {
+			return common.Hash{}, fmt.Errorf("invalid server index %d", index)
+		}
+	}
+	first := servers[onServers[0]]
+	client, err := first.node.Client()
+	if err != nil {
+		return common.Hash{}, &NodeError{Node: first.Name(), Err: err}
+	}
+	var fork []hexutil.Bytes
+	if err := client.Call(&fork, "sim_forkChain", depth); err != nil {
+		return common.Hash{}, &NodeError{Node: first.Name(), Err: err}
+	}
+	head := new(types.Block)
+	if err := rlp.DecodeBytes(fork[len(fork)-1], head); err != nil {
+		return common.Hash{}, err
+	}
+	for _, index := range onServers {
+		client, err := servers[index].node.Client()
+		if err != nil {
+			return common.Hash{}, &NodeError{Node: servers[index].Name(), Err: err}
+		}
+		var hash common.Hash
+		if err := client.Call(&hash, "sim_insertChain", fork); err != nil {
+			return common.Hash{}, &NodeError{Node: servers[index].Name(), Err: err}
+		}
+		// The fork is not the head if the server mined beyond it meanwhile.
+		if hash != head.Hash() {
+			return common.Hash{}, &NodeError{Node: servers[index].Name(), Err: fmt.Errorf("fork is not the head, want %x, got %x", head.Hash(), hash)}
+		}
+	}
+	return head.Hash(), nil
+}
@@ -0,0 +1,91 @@
+package simulator
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth"
+	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestForkChainScratchDatabase(t *testing.T) {
+	cluster := newTestCluster(t, 1, 0, func(config *ClusterConfig) {
+		config.ManualMining = true
+	})
+	defer cluster.Close()
+
+	server := cluster.Servers()[0]
+	backend, ok := server.Node().Node.(*adapters.SimNode).Service("les-server-0").(*eth.Ethereum)
+	if !ok {
+		t.Fatalf("Failed to retrieve server backend")
+	}
+	api := &chainAPI{eth: backend}
+	if _, err := api.ForkChain(5); err == nil {
+		t.Fatalf("Reorg deeper than the chain should be rejected")
+	}
+	fork, err := api.ForkChain(2)
+	if err != nil {
+		t.Fatalf("Failed to generate fork: %v", err)
+	}
+	// The fork state is not written into the chain database
+	for _, blob := range fork {
+		block := new(types.Block)
+		if err := rlp.DecodeBytes(blob, block); err != nil {
+			t.Fatalf("Failed to decode fork block: %v", err)
+		}
+		if ok, _ := backend.ChainDb().Has(block.Root().Bytes()); ok {
+			t.Fatalf("Fork state of block %d is written into the chain database", block.NumberU64())
+		}
+	}
+	if head := backend.BlockChain().CurrentBlock().NumberU64(); head != 4 {
+		t.Fatalf("Unexpected head after generating fork, want 4, got %d", head)
+	}
+}
+
+func TestInjectReorg(t *testing.T) {
+	cluster := newTestCluster(t, 2, 0, func(config *ClusterConfig) {
+		config.ManualMining = true
+	})
+	defer cluster.Close()
+
+	hash, err := cluster.InjectReorg(2, nil)
+	if err != nil {
+		t.Fatalf("Failed to inject reorg: %v", err)
+	}
+	for _, server := range cluster.Servers() {
+		client, err := server.Node().Client()
+		if err != nil {
+			t.Fatalf("Failed to connect %s: %v", server.Name(), err)
+		}
+		var head *types.Header
+		if err := client.Call(&head, "eth_getBlockByNumber", "latest", false); err != nil {
+			t.Fatalf("Failed to retrieve head of %s: %v", server.Name(), err)
+		}
+		if head.Hash() != hash {
+			t.Fatalf("Unexpected head of %s, want %x, got %x", server.Name(), hash, head.Hash())
+		}
+		if head.Coinbase != forkCoinbase || head.Number.Uint64() <= 2 {
+			t.Fatalf("Unexpected fork head of %s, number %d, coinbase %x", server.Name(), head.Number, head.Coinbase)
+		}
+	}
+	if _, err := cluster.InjectReorg(1, []int{2}); err == nil {
+		t.Fatalf("Invalid server index should be rejected")
+	}
+}
+
+func TestInjectReorgNotHead(t *testing.T) {
+	cluster := newTestCluster(t, 2, 0, func(config *ClusterConfig) {
+		config.ManualMining = true
+		config.Miners = []int{1}
+	})
+	defer cluster.Close()
+
+	// The servers are not connected, the second one is far ahead of the fork
+	if err := cluster.MineBlocks(5); err != nil {
+		t.Fatalf("Failed to mine blocks: %v", err)
+	}
+	if _, err := cluster.InjectReorg(2, []int{0, 1}); err == nil {
+		t.Fatalf("Fork behind the head should be rejected")
+	}
+}
@@ -0,0 +1,296 @@
+package simulator
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/robertkrimen/otto/parser"
+)
+
+// RuleTemplate is a parameterized building block of the clef signing rule.
+// Each template contributes a predicate for transaction signing and/or
+// data(e.g. cheque) signing, all the predicates are combined by
+// `NewSigningRules` into a single rule script.
+type RuleTemplate struct {
+	// prelude is the javascript code for defining the helpers and constants
+	// which are used by the predicates. All the `{id}` placeholders in the
+	// template are replaced with a unique identifier of the template.
+	prelude string
+
+	// approveTx is the body of the predicate for transaction signing which
+	// returns true if the request is allowed. Empty means no restriction.
+	approveTx string
+
+	// approveSignData is the body of the predicate for data signing which
+	// returns true if the request is allowed. Empty means no restriction.
+	approveSignData string
+
+	// onApprovedTx is the code to be executed when a transaction is approved
+	// and signed.
+	onApprovedTx string
+
+	// onApprovedSignData is the code to be executed when a data signing
+	// request is approved.
+	onApprovedSignData string
+}
+
+// SpendLimitRule returns the rule which limits the total value that can be
+// spent by transactions within the given sliding window. The transaction is
+// rejected if the total value including it would reach the limit.
+//
+// Note the hand-written rules of cmd/lespay and the signer tests, which this
+// template replaces, used a window of 1000*3600*7 milliseconds, i.e. 7 hours,
+// although they were documented as one week. They now use the full week, e.g.
+// 7*24*time.Hour, which allows less spending in the same time.
+func SpendLimitRule(limit *big.Int, window time.Duration) *RuleTemplate {
+	return &RuleTemplate{
+		prelude: fmt.Sprintf(`
+var window{id} = %d;
+var limit{id} = new BigNumber("%s");`, window.Milliseconds(), limit.String()),
+		approveTx: `
+    var value = big(r.transaction.value)
+    var windowstart = new Date().getTime() - window{id};
+    var txs = load("txs{id}", []);
+
+    // First, remove all that have passed out of the time-window
+    var newtxs = txs.filter(function(tx){return tx.tstamp > windowstart});
+    // Secondly, aggregate the current sum
+    var sum = newtxs.reduce(function(agg, tx){ return big(tx.value).plus(agg)}, new BigNumber(0));
+    // Would we exceed the limit?
+    return sum.plus(value).lt(limit{id})`,
+		onApprovedTx: `
+    var txs = load("txs{id}", []);
+    txs.push({tstamp: new Date().getTime(), value: big(resp.tx.value)});
+    storage.put("txs{id}", JSON.stringify(txs));`,
+	}
+}
+
+// ChequeCapRule returns the rule which limits the accumulated cheque value
+// signed for each server, the cheques are approved until the cap is exactly
+// reached. The key of the caps is the payment address of the server, cheques
+// issued for any server not specified are rejected.
+func ChequeCapRule(caps map[common.Address]*big.Int) *RuleTemplate {
+	var entries []string
+	for addr, limit := range caps {
+		entries = append(entries, fmt.Sprintf("%q: new BigNumber(%q)", strings.ToLower(addr.Hex()), limit.String()))
+	}
+	sort.Strings(entries)
+	return &RuleTemplate{
+		prelude: fmt.Sprintf(`
+var caps{id} = {%s};`, strings.Join(entries, ", ")),
+		approveSignData: `
+    var cheque = chequeOf(r);
+    if (cheque == null) {
+        return true
+    }
+    var limit = caps{id}[cheque.receiver];
+    if (limit === undefined) {
+        return false
+    }
+    var spent = load("cheques{id}", {})[cheque.receiver] || 0;
+    return big(cheque.amount).plus(spent).lte(limit)`,
+		onApprovedSignData: `
+    var cheque = chequeOf(r);
+    if (cheque != null) {
+        var spent = load("cheques{id}", {});
+        spent[cheque.receiver] = big(cheque.amount).plus(spent[cheque.receiver] || 0);
+        storage.put("cheques{id}", JSON.stringify(spent));
+    }`,
+	}
+}
+
+// AllowListRule returns the rule which only allows the transactions sent to
+// the given recipients.
+func AllowListRule(recipients ...common.Address) *RuleTemplate {
+	var entries []string
+	for _, addr := range recipients {
+		entries = append(entries, fmt.Sprintf("%q: true", strings.ToLower(addr.Hex())))
+	}
+	return &RuleTemplate{
+		prelude: fmt.Sprintf(`
+var allowed{id} = {%s};`, strings.Join(entries, ", ")),
+		approveTx: `
+    var to = r.transaction.to;
+    if (!to) {
+        return false
+    }
+    return allowed{id}[to.toLowerCase()] === true`,
+	}
+}
+
+// DenyAllRule returns the rule which rejects all the signing requests.
+func DenyAllRule() *RuleTemplate {
+	return &RuleTemplate{
+		approveTx:       `return false`,
+		approveSignData: `return false`,
+	}
+}
+
+// rulePrelude contains the common helpers shared by all the templates.
+const rulePrelude = `
+// The rules for listing accounts
+function ApproveListing(req) {
+    return "Approve"
+}
+
+// The rules for printing banner.
+function OnSignerStartup(i) {
+    return "Approve"
+}
+
+function big(str) {
+    if (typeof str != "string") {
+        return new BigNumber(str)
+    }
+    if (str.slice(0, 2) == "0x") {
+        return new BigNumber(str.slice(2), 16)
+    }
+    return new BigNumber(str)
+}
+
+// load returns the json object stored with the given key, or the default
+// value if nothing is stored.
+function load(key, def) {
+    var stored = storage.get(key);
+    if (stored == "") {
+        return def
+    }
+    return JSON.parse(stored)
+}
+
+// chequeOf extracts the receiver and amount from the lottery cheque signing
+// request, null is returned if the request is not for cheque.
+function chequeOf(r) {
+    if (!r.messages) {
+        return null
+    }
+    var cheque = {};
+    for (var i = 0; i < r.messages.length; i++) {
+        var name = r.messages[i].name.toLowerCase();
+        if (name == "receiver" || name == "amount") {
+            cheque[name] = r.messages[i].value
+        }
+    }
+    if (cheque.receiver === undefined || cheque.amount === undefined) {
+        return null
+    }
+    cheque.receiver = cheque.receiver.toLowerCase();
+    return cheque
+}
+`
+
+// NewSigningRules combines the given templates into a clef rule script which
+// can be used as the `ClusterConfig.SigningRule`. A request is approved only
+// if all the templates allow it, the request without any restriction is
+// approved by default.
+func NewSigningRules(templates ...*RuleTemplate) []byte {
+	var (
+		buf             bytes.Buffer
+		txChecks        []string
+		signDataChecks  []string
+		onTx, onSigData []string
+	)
+	buf.WriteString(rulePrelude)
+	for index, t := range templates {
+		var (
+			id     = fmt.Sprintf("_%d", index)
+			expand = func(code string) string { return strings.Replace(code, "{id}", id, -1) }
+		)
+		if t.prelude != "" {
+			buf.WriteString(expand(t.prelude))
+			buf.WriteString("\n")
+		}
+		if t.approveTx != "" {
+			fmt.Fprintf(&buf, "\nfunction approveTx%s(r) {\n    %s\n}\n", id, strings.TrimSpace(expand(t.approveTx)))
+			txChecks = append(txChecks, fmt.Sprintf("approveTx%s(r)", id))
+		}
+		if t.approveSignData != "" {
+			fmt.Fprintf(&buf, "\nfunction approveSignData%s(r) {\n    %s\n}\n", id, strings.TrimSpace(expand(t.approveSignData)))
+			signDataChecks = append(signDataChecks, fmt.Sprintf("approveSignData%s(r)", id))
+		}
+		if t.onApprovedTx != "" {
+			onTx = append(onTx, expand(t.onApprovedTx))
+		}
+		if t.onApprovedSignData != "" {
+			onSigData = append(onSigData, expand(t.onApprovedSignData))
+		}
+	}
+	writeApprove := func(name string, checks []string, hook string) {
+		fmt.Fprintf(&buf, "\nfunction %s(r) {\n", name)
+		for _, check := range checks {
+			fmt.Fprintf(&buf, "    if (!%s) {\n        return \"Reject\"\n    }\n", check)
+		}
+		if hook != "" {
+			fmt.Fprintf(&buf, "    %s(r);\n", hook)
+		}
+		buf.WriteString("    return \"Approve\"\n}\n")
+	}
+	// The rules for signing transactions
+	writeApprove("ApproveTx", txChecks, "")
+
+	// OnApprovedTx(str) is called when a transaction has been approved and signed.
+	fmt.Fprintf(&buf, "\nfunction OnApprovedTx(resp) {%s\n}\n", strings.Join(onTx, ""))
+
+	// The rules for signing cheques. There is no callback for approved data
+	// signing in clef, so the accounting is done when the request is approved.
+	var hook string
+	if len(onSigData) > 0 {
+		hook = "onApprovedSignData"
+		fmt.Fprintf(&buf, "\nfunction %s(r) {%s\n}\n", hook, strings.Join(onSigData, ""))
+	}
+	writeApprove("ApproveSignData", signDataChecks, hook)
+	return buf.Bytes()
+}
+
+// RuleDiagnostic is a single problem found in the signing rules.
+type RuleDiagnostic struct {
+	Line    int    // 1-based line number, 0 if the position is unknown
+	Column  int    // 1-based column number, 0 if the position is unknown
+	Message string // Description of the problem
+}
+
+func (d RuleDiagnostic) String() string {
+	return fmt.Sprintf("line %d:%d: %s", d.Line, d.Column, d.Message)
+}
+
+// RuleError is returned if the signing rules are invalid, it contains all
+// the problems found.
+type RuleError struct {
+	Diagnostics []RuleDiagnostic
+}
+
+func (e *RuleError) Error() string {
+	var msgs []string
+	for _, d := range e.Diagnostics {
+		msgs = append(msgs, d.String())
+	}
+	return fmt.Sprintf("invalid signing rules: %s", strings.Join(msgs, "; "))
+}
+
+// ValidateRules checks the syntax of the given clef rule script. The returned
+// error is a *RuleError with the line numbers of the problems if the rules
+// are invalid.
+func ValidateRules(rules []byte) error {
+	_, err := parser.ParseFile(nil, "", string(rules), 0)
+	if err == nil {
+		return nil
+	}
+	list, ok := err.(parser.ErrorList)
+	if !ok {
+		return &RuleError{Diagnostics: []RuleDiagnostic{{Message: err.Error()}}}
+	}
+	var diags []RuleDiagnostic
+	for _, e := range list {
+		diags = append(diags, RuleDiagnostic{
+			Line:    e.Position.Line,
+			Column:  e.Position.Column,
+			Message: e.Message,
+		})
+	}
+	return &RuleError{Diagnostics: diags}
+}
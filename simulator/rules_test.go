@@ -0,0 +1,245 @@
+package simulator
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/signer/core"
+	"github.com/ethereum/go-ethereum/signer/rules"
+	"github.com/ethereum/go-ethereum/signer/storage"
+)
+
+func TestSigningRules(t *testing.T) {
+	var (
+		server = common.HexToAddress("0xdeadbeef")
+		script = NewSigningRules(
+			SpendLimitRule(big.NewInt(5e16), 7*24*time.Hour),
+			ChequeCapRule(map[common.Address]*big.Int{server: big.NewInt(1e15)}),
+			AllowListRule(server),
+		)
+	)
+	if bytes.Contains(script, []byte("{id}")) {
+		t.Fatalf("Unexpanded placeholder in rules")
+	}
+	if !bytes.Equal(script, NewSigningRules(
+		SpendLimitRule(big.NewInt(5e16), 7*24*time.Hour),
+		ChequeCapRule(map[common.Address]*big.Int{server: big.NewInt(1e15)}),
+		AllowListRule(server),
+	)) {
+		t.Fatalf("Rules are not deterministic")
+	}
+}
+
+// manualUI is the fallback of the rule engine, which is only consulted if the
+// rules fail to make a decision, e.g. the script throws.
+type manualUI struct {
+	core.UIClientAPI
+	t *testing.T
+}
+
+func (ui *manualUI) ApproveTx(request *core.SignTxRequest) (core.SignTxResponse, error) {
+	ui.t.Errorf("Rules failed to decide on transaction %v", request.Transaction)
+	return core.SignTxResponse{Approved: false}, nil
+}
+
+func (ui *manualUI) ApproveSignData(request *core.SignDataRequest) (core.SignDataResponse, error) {
+	ui.t.Errorf("Rules failed to decide on data %v", request.Messages)
+	return core.SignDataResponse{Approved: false}, nil
+}
+
+// ruleEngine runs the given rules with the clef rule evaluator.
+type ruleEngine struct {
+	t       *testing.T
+	ui      core.UIClientAPI
+	storage storage.Storage
+}
+
+func newRuleEngine(t *testing.T, script []byte) *ruleEngine {
+	if err := ValidateRules(script); err != nil {
+		t.Fatalf("Invalid rules: %v", err)
+	}
+	store := storage.NewEphemeralStorage()
+	ui, err := rules.NewRuleEvaluator(&manualUI{t: t}, store)
+	if err != nil {
+		t.Fatalf("Failed to create rule evaluator: %v", err)
+	}
+	if err := ui.Init(string(script)); err != nil {
+		t.Fatalf("Failed to init rules: %v", err)
+	}
+	return &ruleEngine{t: t, ui: ui, storage: store}
+}
+
+// tx asks the rules whether the transaction to the given recipient is signed,
+// the nil recipient means contract creation.
+func (e *ruleEngine) tx(to *common.Address, value int64) bool {
+	req := &core.SignTxRequest{Transaction: core.SendTxArgs{
+		From:  common.NewMixedcaseAddress(common.HexToAddress("0x01")),
+		Value: hexutil.Big(*big.NewInt(value)),
+	}}
+	if to != nil {
+		recipient := common.NewMixedcaseAddress(*to)
+		req.Transaction.To = &recipient
+	}
+	resp, err := e.ui.ApproveTx(req)
+	if err != nil {
+		e.t.Fatalf("Failed to approve transaction: %v", err)
+	}
+	return resp.Approved
+}
+
+// cheque asks the rules whether the cheque for the given receiver is signed.
+func (e *ruleEngine) cheque(receiver common.Address, amount int64) bool {
+	return e.signData([]*core.NameValueType{
+		{Name: "receiver", Typ: "address", Value: receiver.Hex()},
+		{Name: "amount", Typ: "uint256", Value: big.NewInt(amount).String()},
+	})
+}
+
+func (e *ruleEngine) signData(messages []*core.NameValueType) bool {
+	resp, err := e.ui.ApproveSignData(&core.SignDataRequest{
+		Address:  common.NewMixedcaseAddress(common.HexToAddress("0x01")),
+		Messages: messages,
+	})
+	if err != nil {
+		e.t.Fatalf("Failed to approve data: %v", err)
+	}
+	return resp.Approved
+}
+
+// spent records the transaction signed at the given time in the storage of
+// the spend limit rule with the given id, as OnApprovedTx does.
+func (e *ruleEngine) spent(id string, at time.Time, value int64) {
+	var txs []map[string]interface{}
+	if stored, err := e.storage.Get("txs" + id); err == nil && stored != "" {
+		if err := json.Unmarshal([]byte(stored), &txs); err != nil {
+			e.t.Fatalf("Failed to decode spent transactions: %v", err)
+		}
+	}
+	txs = append(txs, map[string]interface{}{"tstamp": at.UnixNano() / int64(time.Millisecond), "value": big.NewInt(value).String()})
+	blob, _ := json.Marshal(txs)
+	e.storage.Put("txs"+id, string(blob))
+}
+
+func TestSpendLimitRule(t *testing.T) {
+	var (
+		to     = common.HexToAddress("0xdeadbeef")
+		engine = newRuleEngine(t, NewSigningRules(SpendLimitRule(big.NewInt(100), time.Hour)))
+	)
+	if !engine.tx(&to, 99) {
+		t.Fatal("Transaction below the limit is rejected")
+	}
+	if engine.tx(&to, 100) {
+		t.Fatal("Transaction reaching the limit is approved")
+	}
+	// The spent value within the window counts, the older one doesn't.
+	engine.spent("_0", time.Now(), 60)
+	engine.spent("_0", time.Now().Add(-2*time.Hour), 1000)
+	if !engine.tx(&to, 39) {
+		t.Fatal("Transaction below the remaining limit is rejected")
+	}
+	if engine.tx(&to, 40) {
+		t.Fatal("Transaction reaching the remaining limit is approved")
+	}
+}
+
+func TestChequeCapRule(t *testing.T) {
+	var (
+		server  = common.HexToAddress("0xdeadbeef")
+		unknown = common.HexToAddress("0xcafebabe")
+		engine  = newRuleEngine(t, NewSigningRules(ChequeCapRule(map[common.Address]*big.Int{server: big.NewInt(100)})))
+	)
+	if !engine.cheque(server, 60) {
+		t.Fatal("Cheque below the cap is rejected")
+	}
+	// The signed cheques are accumulated, the cap can be reached exactly.
+	if engine.cheque(server, 41) {
+		t.Fatal("Cheque exceeding the cap is approved")
+	}
+	if !engine.cheque(server, 40) {
+		t.Fatal("Cheque reaching the cap is rejected")
+	}
+	if engine.cheque(server, 1) {
+		t.Fatal("Cheque beyond the reached cap is approved")
+	}
+	if engine.cheque(unknown, 1) {
+		t.Fatal("Cheque for unknown server is approved")
+	}
+	if !engine.signData([]*core.NameValueType{{Name: "message", Typ: "string", Value: "hello"}}) {
+		t.Fatal("Non-cheque data is rejected")
+	}
+	if !engine.tx(&unknown, 1000) {
+		t.Fatal("Transaction is restricted by cheque cap")
+	}
+}
+
+func TestAllowListRule(t *testing.T) {
+	var (
+		allowed = common.HexToAddress("0xdeadbeef")
+		other   = common.HexToAddress("0xcafebabe")
+		engine  = newRuleEngine(t, NewSigningRules(AllowListRule(allowed)))
+	)
+	if !engine.tx(&allowed, 1) {
+		t.Fatal("Transaction to allowed recipient is rejected")
+	}
+	if engine.tx(&other, 1) {
+		t.Fatal("Transaction to other recipient is approved")
+	}
+	if engine.tx(nil, 1) {
+		t.Fatal("Contract creation is approved")
+	}
+	if !engine.cheque(other, 1) {
+		t.Fatal("Cheque is restricted by allow list")
+	}
+}
+
+func TestDenyAllRule(t *testing.T) {
+	var (
+		to     = common.HexToAddress("0xdeadbeef")
+		engine = newRuleEngine(t, NewSigningRules(DenyAllRule()))
+	)
+	if engine.tx(&to, 1) {
+		t.Fatal("Transaction is approved")
+	}
+	if engine.cheque(to, 1) {
+		t.Fatal("Cheque is approved")
+	}
+}
+
+func TestCombinedRules(t *testing.T) {
+	var (
+		allowed = common.HexToAddress("0xdeadbeef")
+		other   = common.HexToAddress("0xcafebabe")
+		engine  = newRuleEngine(t, NewSigningRules(SpendLimitRule(big.NewInt(100), time.Hour), AllowListRule(allowed)))
+	)
+	if !engine.tx(&allowed, 50) {
+		t.Fatal("Transaction allowed by all rules is rejected")
+	}
+	if engine.tx(&other, 50) {
+		t.Fatal("Transaction rejected by allow list is approved")
+	}
+	if engine.tx(&allowed, 100) {
+		t.Fatal("Transaction rejected by spend limit is approved")
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	if err := ValidateRules(NewSigningRules(SpendLimitRule(big.NewInt(5e16), time.Hour), DenyAllRule())); err != nil {
+		t.Fatalf("Failed to validate rules, err: %v", err)
+	}
+	err := ValidateRules([]byte("function ApproveTx(r) {\n    return \"Approve\"\n}\n\nfunction ApproveSignData(r) {\n    return (\n}"))
+	if err == nil {
+		t.Fatalf("Invalid rules are not detected")
+	}
+	rerr, ok := err.(*RuleError)
+	if !ok {
+		t.Fatalf("Unexpected error type %T", err)
+	}
+	if len(rerr.Diagnostics) == 0 || rerr.Diagnostics[0].Line != 7 {
+		t.Fatalf("Unexpected diagnostics %v", rerr.Diagnostics)
+	}
+}
@@ -0,0 +1,153 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// PriceFactors are the factors for calculating the cost of the client, which
+// is deducted from its balance.
+type PriceFactors struct {
+	TimeFactor        float64 // Cost per second of the connected time
+	CapacityFactor    float64 // Cost per second per unit of capacity
+	RequestCostFactor float64 // Cost per unit of the request cost
+}
+
+// params returns the price factors as the parameters of the les_setDefaultParams
+// with the given key prefix.
+func (f *PriceFactors) params(prefix string, params map[string]interface{}) {
+	params[prefix+"timeFactor"] = f.TimeFactor
+	params[prefix+"capacityFactor"] = f.CapacityFactor
+	params[prefix+"requestCostFactor"] = f.RequestCostFactor
+}
+
+// serverParams is the life cycle which applies the server settings available
+// only via the server RPC API when the server is started.
+type serverParams struct {
+	stack  *node.Node
+	config *ServerServiceConfig
+}
+
+// Start implements node.Lifecycle, applying the server settings.
+func (s *serverParams) Start() error {
+	params := make(map[string]interface{})
+	if s.config.PriceFactors != nil {
+		s.config.PriceFactors.params("pricing/", params)
+	}
+	if s.config.NegativePriceFactors != nil {
+		s.config.NegativePriceFactors.params("pricing/negative/", params)
+	}
+	if len(params) == 0 && s.config.ConnectedBias == 0 {
+		return nil
+	}
+	client, err := s.stack.Attach()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if len(params) > 0 {
+		if err := client.Call(nil, "les_setDefaultParams", params); err != nil {
+			return fmt.Errorf("failed to set price factors: %v", err)
+		}
+	}
+	if s.config.ConnectedBias != 0 {
+		if err := client.Call(nil, "les_setConnectedBias", s.config.ConnectedBias); err != nil {
+			return fmt.Errorf("failed to set connected bias: %v", err)
+		}
+	}
+	return nil
+}
+
+// Stop implements node.Lifecycle, it's a noop.
+func (s *serverParams) Stop() error { return nil }
+
+// ServerInfo returns the capacity information of the server via the server
+// RPC API, e.g. the total capacity and the capacity assigned to the free
+// clients("freeClientCapacity").
+func (cluster *Cluster) ServerInfo(index int) (map[string]interface{}, error) {
+	servers := cluster.Servers()
+	if index < 0 || index >= len(servers) {
+		return nil, fmt.Errorf("invalid server index %d", index)
+	}
+	client, err := servers[index].node.Client()
+	if err != nil {
+		return nil, &NodeError{Node: servers[index].Name(), Err: err}
+	}
+	var info map[string]interface{}
+	if err := client.Call(&info, "les_serverInfo"); err != nil {
+		return nil, &NodeError{Node: servers[index].Name(), Err: err}
+	}
+	return info, nil
+}
+
+// serverClientPair returns the RPC client of the server and the node ID of
+// the client.
+func (cluster *Cluster) serverClientPair(server, client int) (*LesServer, *rpc.Client, enode.ID, error) {
+	var (
+		servers = cluster.Servers()
+		clients = cluster.Clients()
+	)
+	if server < 0 || server >= len(servers) {
+		return nil, nil, enode.ID{}, fmt.Errorf("invalid server index %d", server)
+	}
+	if client < 0 || client >= len(clients) {
+		return nil, nil, enode.ID{}, fmt.Errorf("invalid client index %d", client)
+	}
+	rpcClient, err := servers[server].node.Client()
+	if err != nil {
+		return nil, nil, enode.ID{}, &NodeError{Node: servers[server].Name(), Err: err}
+	}
+	return servers[server], rpcClient, clients[client].node.ID(), nil
+}
+
+// SetClientPriority assigns the given capacity to the client in the server,
+// which makes it a priority client. The client must have the positive balance
+// in the server, see AddClientBalance, and it must be connected, the server
+// rejects the parameters of the unknown clients. The capacity can't be lower
+// than the minimum capacity of the server, see ServerInfo, so the client can't
+// be turned back into a free client by the zero capacity.
+func (cluster *Cluster) SetClientPriority(server, client int, capacity uint64) error {
+	s, rpcClient, id, err := cluster.serverClientPair(server, client)
+	if err != nil {
+		return err
+	}
+	params := map[string]interface{}{"capacity": capacity}
+	if err := rpcClient.Call(nil, "les_setClientParams", []enode.ID{id}, params); err != nil {
+		return &NodeError{Node: s.Name(), Err: err}
+	}
+	return nil
+}
+
+// AddClientBalance adds the given amount to the positive balance of the client
+// in the server, the negative amount deducts the balance. The new balance is
+// returned.
+func (cluster *Cluster) AddClientBalance(server, client int, amount int64) (uint64, error) {
+	s, rpcClient, id, err := cluster.serverClientPair(server, client)
+	if err != nil {
+		return 0, err
+	}
+	var result json.RawMessage
+	if err := rpcClient.Call(&result, "les_addBalance", id, amount); err != nil {
+		return 0, &NodeError{Node: s.Name(), Err: err}
+	}
+	balance, err := decodeBalance(result)
+	if err != nil {
+		return 0, &NodeError{Node: s.Name(), Err: err}
+	}
+	return balance, nil
+}
+
+// decodeBalance decodes the new balance from the result of les_addBalance,
+// which is the balances before and after the change.
+func decodeBalance(result json.RawMessage) (uint64, error) {
+	var balances [2]uint64
+	if err := json.Unmarshal(result, &balances); err != nil {
+		return 0, fmt.Errorf("invalid balance %s", result)
+	}
+	return balances[1], nil
+}
@@ -0,0 +1,107 @@
+package simulator
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestPriceFactorParams(t *testing.T) {
+	params := make(map[string]interface{})
+	(&PriceFactors{TimeFactor: 1, CapacityFactor: 2, RequestCostFactor: 3}).params("pricing/", params)
+	(&PriceFactors{TimeFactor: 4}).params("pricing/negative/", params)
+
+	want := map[string]float64{
+		"pricing/timeFactor":                 1,
+		"pricing/capacityFactor":             2,
+		"pricing/requestCostFactor":          3,
+		"pricing/negative/timeFactor":        4,
+		"pricing/negative/capacityFactor":    0,
+		"pricing/negative/requestCostFactor": 0,
+	}
+	if len(params) != len(want) {
+		t.Fatalf("Unexpected parameter number, want %d, got %d", len(want), len(params))
+	}
+	for key, value := range want {
+		if params[key] != value {
+			t.Fatalf("Unexpected parameter %s, want %v, got %v", key, value, params[key])
+		}
+	}
+}
+
+func TestDecodeBalance(t *testing.T) {
+	balance, err := decodeBalance(json.RawMessage("[10,42]"))
+	if err != nil {
+		t.Fatalf("Failed to decode balance: %v", err)
+	}
+	if balance != 42 {
+		t.Fatalf("Unexpected balance, want 42, got %d", balance)
+	}
+	for _, result := range []string{"42", `"42"`} {
+		if _, err := decodeBalance(json.RawMessage(result)); err == nil {
+			t.Fatalf("Invalid balance %s should be rejected", result)
+		}
+	}
+}
+
+func TestClientBalance(t *testing.T) {
+	cluster := newTestCluster(t, 1, 1, func(config *ClusterConfig) {
+		config.ServerConfig[0].LightNoSyncServe = true // The server is never synced without the peers
+	})
+	defer cluster.Close()
+
+	const amount = 1000000000000000
+	balance, err := cluster.AddClientBalance(0, 0, amount)
+	if err != nil {
+		t.Fatalf("Failed to add balance: %v", err)
+	}
+	if balance != amount {
+		t.Fatalf("Unexpected balance, want %d, got %d", amount, balance)
+	}
+	if err := cluster.Connect(); err != nil {
+		t.Fatalf("Failed to connect cluster: %v", err)
+	}
+	// The capacity is reported as the free client capacity by the fork and as
+	// the minimum capacity upstream.
+	info, err := cluster.ServerInfo(0)
+	if err != nil {
+		t.Fatalf("Failed to retrieve server info: %v", err)
+	}
+	capacity, ok := info["freeClientCapacity"].(float64)
+	if !ok {
+		capacity, ok = info["minimumCapacity"].(float64)
+	}
+	if !ok || capacity == 0 {
+		t.Fatalf("Unexpected server info %v", info)
+	}
+	// The client is prioritized once the connection is established
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := cluster.SetClientPriority(0, 0, 2*uint64(capacity))
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to set client priority: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// The balance of the connected priority client is charged over time
+	prev := balance
+	if balance, err = cluster.AddClientBalance(0, 0, amount); err != nil {
+		t.Fatalf("Failed to add balance: %v", err)
+	}
+	if balance <= prev || balance > prev+amount {
+		t.Fatalf("Unexpected balance, previous %d, got %d", prev, balance)
+	}
+	prev = balance
+	if balance, err = cluster.AddClientBalance(0, 0, -amount); err != nil {
+		t.Fatalf("Failed to deduct balance: %v", err)
+	}
+	if balance > prev-amount {
+		t.Fatalf("Unexpected balance after deduction, previous %d, got %d", prev, balance)
+	}
+	if _, err := cluster.AddClientBalance(0, 1, amount); err == nil {
+		t.Fatalf("Invalid client index should be rejected")
+	}
+}
@@ -1,6 +1,8 @@
 package simulator
 
 import (
+	"time"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/ethash"
 	"github.com/ethereum/go-ethereum/core"
@@ -11,12 +13,23 @@ import (
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p/simulations/adapters"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 // BlockchainConfig contains the setting for chain state.
 type BlockchainConfig struct {
 	Genesis *core.Genesis  // Nil if no customized genesis is required
 	Chain   []*types.Block // Nil if the initial state is empty
+
+	// BlockInterval is the interval between the blocks mined by the servers.
+	// The default value is 0 which means the blocks are mined continuously.
+	BlockInterval time.Duration
+
+	// ManualMining is the flag whether the automatic mining of the servers
+	// is paused initially, the blocks are only mined by `Cluster.MineBlocks`
+	// until the mining is resumed.
+	ManualMining bool
 }
 
 type ClientServiceConfig struct {
@@ -28,20 +41,78 @@ type ClientServiceConfig struct {
 	// The default is empty, which means the lottery payment is disabled.
 	PaymentAddress common.Address
 
-	// TrustedServers is the list of trusted ultra light servers.
+	// TrustedServers is the list of trusted ultra light servers. Besides the
+	// enode URLs, the servers in the cluster can be referenced in the topology
+	// style, e.g. "s0", which are resolved when the cluster is created.
 	//
 	// The default value is empty, which means no trusted server will be
 	// picked.
 	TrustedServers []string
 
+	// TrustedServerIndexes is the list of indexes of the trusted ultra light
+	// servers in the cluster. They are resolved to the enode URLs when the
+	// cluster is created and passed to the client via the node properties,
+	// then merged with `TrustedServers`, which itself is left unchanged.
+	TrustedServerIndexes []int
+
 	// TrustedFraction is the percentage of trusted servers to accept an
 	// announcement. It's only meaningful when `TrustedServers` is not empty.
 	TrustedFraction int
 
+	// UltraLightOnlyAnnounce is the flag whether to only accept the announced
+	// heads from the trusted servers, instead of requesting the headers from
+	// them for verification.
+	UltraLightOnlyAnnounce bool
+
+	// Checkpoint is the hardcoded checkpoint the client syncs from, and
+	// CheckpointOracle is the checkpoint oracle contract for retrieving the
+	// latest checkpoint.
+	//
+	// The default value is nil which means the built-in settings of the
+	// genesis are used.
+	Checkpoint       *params.TrustedCheckpoint
+	CheckpointOracle *params.CheckpointOracleConfig
+
+	// SyncFromCheckpoint is the flag whether to sync from the configured
+	// checkpoint even if no checkpoint oracle is available.
+	SyncFromCheckpoint bool
+
+	// LightPeers is the maximum number of the connected servers.
+	//
+	// The default value is 0 which means the go-ethereum default is used.
+	LightPeers int
+
+	// PinnedServers is the list of indexes of the servers which the client
+	// always keeps connected with, regardless of the server pool selection
+	// and the peer limit. The servers are resolved to the enode URLs when
+	// the cluster is created.
+	PinnedServers []int
+
 	// ClefEnabled is the flag whether to enable external signer clef for
 	// managing the user accounts.
 	ClefEnabled bool
 
+	// Behavior makes the client misbehave when requesting the servers, see
+	// `ClientBehavior` for the details.
+	//
+	// The default value is nil which means the client is honest.
+	Behavior *ClientBehavior
+
+	// Binary is the path of the node executable for running this node. It's
+	// only meaningful for the exec adapter. The binary must be built from the
+	// same simulation program, but it can be linked against a different
+	// go-ethereum version so that nodes with different LES versions can be
+	// mixed in a single cluster.
+	//
+	// The default value is empty which means the current binary is used.
+	Binary string
+
+	// TraceFile is the file for recording all the protocol messages exchanged
+	// with the other nodes, see `TraceRecord` for the format.
+	//
+	// The default value is empty which means the tracing is disabled.
+	TraceFile string
+
 	// LogFile is the log file name of the p2p node at runtime.
 	//
 	// The default value is empty so that the default log writer
@@ -65,8 +136,15 @@ func NewLesClientService(cfg *ClientServiceConfig, bcfg *BlockchainConfig) func(
 		// Add more customized configs
 		if cfg != nil {
 			config.LotteryPaymentAddress = cfg.PaymentAddress
-			config.UltraLightServers = cfg.TrustedServers
+			config.UltraLightServers = trustedServerURLs(cfg.TrustedServers, ctx.Config.Properties)
 			config.UltraLightFraction = cfg.TrustedFraction
+			config.UltraLightOnlyAnnounce = cfg.UltraLightOnlyAnnounce
+			config.Checkpoint = cfg.Checkpoint
+			config.CheckpointOracle = cfg.CheckpointOracle
+			config.SyncFromCheckpoint = cfg.SyncFromCheckpoint
+			if cfg.LightPeers > 0 {
+				config.LightPeers = cfg.LightPeers
+			}
 		}
 		if bcfg != nil && bcfg.Genesis != nil {
 			config.Genesis = bcfg.Genesis
@@ -75,6 +153,23 @@ func NewLesClientService(cfg *ClientServiceConfig, bcfg *BlockchainConfig) func(
 		if err != nil {
 			return nil, err
 		}
+		if pinned := propertyValues(ctx.Config.Properties, pinnedServerProperty); len(pinned) > 0 {
+			pinner, err := newServerPinner(stack, pinned)
+			if err != nil {
+				return nil, err
+			}
+			stack.RegisterLifecycle(pinner)
+		}
+		if cfg != nil && cfg.Behavior != nil {
+			cfg.Behavior.Wrap(stack)
+		}
+		if cfg != nil && cfg.TraceFile != "" {
+			tracer, err := NewTracer(ctx.Config.Name, cfg.TraceFile)
+			if err != nil {
+				return nil, err
+			}
+			tracer.Wrap(stack)
+		}
 		// Do initialization.
 		if bcfg != nil && len(bcfg.Chain) > 0 {
 			var headers []*types.Header
@@ -104,6 +199,63 @@ type ServerServiceConfig struct {
 	// LightPeers is the maximum number of LES client peers.
 	LightPeers int
 
+	// LightIngress and LightEgress are the incoming and outgoing bandwidth
+	// limits for serving the light clients in kilobytes/sec.
+	//
+	// The default value is 0 which means unlimited.
+	LightIngress int
+	LightEgress  int
+
+	// LightNoPrune is the flag whether to disable the pruning of the ancient
+	// light chain data(CHT and bloom trie).
+	LightNoPrune bool
+
+	// LightNoSyncServe is the flag whether to serve the light clients even
+	// before the server is synced.
+	LightNoSyncServe bool
+
+	// PriceFactors and NegativePriceFactors are the default price factors
+	// for the positive and negative balance of the clients. They are applied
+	// via the server RPC API when the server is started.
+	//
+	// The default value is nil which means the server defaults are used.
+	PriceFactors         *PriceFactors
+	NegativePriceFactors *PriceFactors
+
+	// ConnectedBias is the bias applied to the already connected clients when
+	// the server is full, so that they are not kicked out by the new ones
+	// with slightly higher priority.
+	//
+	// The default value is 0 which means the server default is used.
+	ConnectedBias time.Duration
+
+	// Chain overrides the initial chain shared by all the servers, see
+	// `ChainOverride` for the details.
+	//
+	// The default value is nil which means the shared chain is used.
+	Chain *ChainOverride
+
+	// Behavior makes the server misbehave when serving the clients, see
+	// `ServerBehavior` for the details.
+	//
+	// The default value is nil which means the server is honest.
+	Behavior *ServerBehavior
+
+	// Binary is the path of the node executable for running this node. It's
+	// only meaningful for the exec adapter. The binary must be built from the
+	// same simulation program, but it can be linked against a different
+	// go-ethereum version so that nodes with different LES versions can be
+	// mixed in a single cluster.
+	//
+	// The default value is empty which means the current binary is used.
+	Binary string
+
+	// TraceFile is the file for recording all the protocol messages exchanged
+	// with the other nodes, see `TraceRecord` for the format.
+	//
+	// The default value is empty which means the tracing is disabled.
+	TraceFile string
+
 	// LogFile is the log file name of the p2p node at runtime.
 	//
 	// The default value is empty so that the default log writer
@@ -130,6 +282,10 @@ func NewLesServerService(cfg *ServerServiceConfig, bcfg *BlockchainConfig, minin
 			config.LotteryPaymentAddress = cfg.PaymentAddress
 			config.LightServ = cfg.LightServ
 			config.LightPeers = cfg.LightPeers
+			config.LightIngress = cfg.LightIngress
+			config.LightEgress = cfg.LightEgress
+			config.LightNoPrune = cfg.LightNoPrune
+			config.LightNoSyncServe = cfg.LightNoSyncServe
 		}
 		if bcfg != nil && bcfg.Genesis != nil {
 			config.Genesis = bcfg.Genesis
@@ -142,14 +298,39 @@ func NewLesServerService(cfg *ServerServiceConfig, bcfg *BlockchainConfig, minin
 		if bcfg != nil && len(bcfg.Chain) > 0 {
 			eth.BlockChain().InsertChain(bcfg.Chain)
 		}
+		stack.RegisterAPIs([]rpc.API{{
+			Namespace: "sim",
+			Version:   "1.0",
+			Service:   &chainAPI{eth: eth},
+			Public:    true,
+		}})
 		_, err = les.NewLesServer(stack, eth, &config)
 		if err != nil {
 			return nil, err
 		}
-		// If mining is required, start it
+		if cfg != nil && cfg.Behavior != nil {
+			cfg.Behavior.Wrap(stack)
+		}
+		if cfg != nil && cfg.TraceFile != "" {
+			tracer, err := NewTracer(ctx.Config.Name, cfg.TraceFile)
+			if err != nil {
+				return nil, err
+			}
+			tracer.Wrap(stack)
+		}
+		if cfg != nil {
+			stack.RegisterLifecycle(&serverParams{stack: stack, config: cfg})
+		}
+		// If mining is required, start it along with the node
 		if mining {
-			eth.Miner().DisablePreseal()
-			eth.StartMining(1)
+			var (
+				interval time.Duration
+				manual   bool
+			)
+			if bcfg != nil {
+				interval, manual = bcfg.BlockInterval, bcfg.ManualMining
+			}
+			stack.RegisterLifecycle(newBlockProducer(stack, eth, interval, manual))
 		}
 		return eth, nil
 	}
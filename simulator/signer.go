@@ -2,12 +2,18 @@ package simulator
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"time"
 
+	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/ethereum/go-ethereum/signer/core"
@@ -28,6 +34,13 @@ type ClefConfig struct {
 	MasterSeed string                    // Default seed is used if empty
 	Rules      []byte                    // Empty means no additional rules
 	Accounts   map[common.Address]string // Unlock account
+
+	// HTTP and WebSocket endpoints in addition to the IPC, they are only
+	// opened on localhost. Zero port means a random free port is picked.
+	HTTPEnabled bool
+	HTTPPort    int
+	WSEnabled   bool
+	WSPort      int
 }
 
 type ClefDaemon struct {
@@ -36,12 +49,58 @@ type ClefDaemon struct {
 	server   *rpc.Server
 	rpcURL   string
 	ui       core.UIClientAPI
+	am       *accounts.Manager
+
+	httpServer *http.Server // Nil if the http endpoint is disabled
+	httpURL    string
+	wsServer   *http.Server // Nil if the websocket endpoint is disabled
+	wsURL      string
+
+	observed *observedUI
+	stopOnce sync.Once
+}
+
+// SignerDecision is the decision made by the signer for a signing request.
+type SignerDecision struct {
+	Time     time.Time
+	Request  string // Type of the request, "tx" or "data"
+	Account  common.Address
+	Approved bool
+}
+
+// observedUI is the wrapper of the signer UI which publishes all the decisions
+// made for the signing requests.
+type observedUI struct {
+	core.UIClientAPI
+	feed event.Feed
+}
+
+func (ui *observedUI) ApproveTx(request *core.SignTxRequest) (core.SignTxResponse, error) {
+	resp, err := ui.UIClientAPI.ApproveTx(request)
+	ui.feed.Send(&SignerDecision{
+		Time:     time.Now(),
+		Request:  "tx",
+		Account:  request.Transaction.From.Address(),
+		Approved: err == nil && resp.Approved,
+	})
+	return resp, err
+}
+
+func (ui *observedUI) ApproveSignData(request *core.SignDataRequest) (core.SignDataResponse, error) {
+	resp, err := ui.UIClientAPI.ApproveSignData(request)
+	ui.feed.Send(&SignerDecision{
+		Time:     time.Now(),
+		Request:  "data",
+		Account:  request.Address.Address(),
+		Approved: err == nil && resp.Approved,
+	})
+	return resp, err
 }
 
 func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 	// Check config validity
 	if config == nil {
-		return nil, errors.New("emoty config")
+		return nil, errors.New("empty config")
 	}
 	if config.Dir == "" {
 		return nil, errors.New("no directory specified")
@@ -53,7 +112,7 @@ func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 	ui = core.NewCommandlineUI()
 	fbdb, err := fourbyte.NewWithFile("")
 	if err != nil {
-		return nil, errors.New("failed to open fourbyte db")
+		return nil, fmt.Errorf("failed to open fourbyte db: %v", err)
 	}
 	masterSeed := config.MasterSeed
 	if masterSeed == "" {
@@ -66,7 +125,9 @@ func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 	jskey := crypto.Keccak256([]byte("jsstorage"), []byte(masterSeed))
 
 	// Make directory
-	os.MkdirAll(vaultLocation, 0777)
+	if err := os.MkdirAll(vaultLocation, 0777); err != nil {
+		return nil, err
+	}
 
 	// Initialize the encrypted storages
 	pwStorage := storage.NewAESEncryptedStorage(filepath.Join(vaultLocation, "credentials.json"), pwkey)
@@ -74,12 +135,19 @@ func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 
 	// Do we have a rule-file?
 	if len(config.Rules) > 0 {
+		// Reject the broken rules before feeding them into the engine,
+		// otherwise the failure is only reported on the first request.
+		if err := ValidateRules(config.Rules); err != nil {
+			return nil, err
+		}
 		// Initialize rules
 		ruleEngine, err := rules.NewRuleEvaluator(ui, jsStorage)
 		if err != nil {
-			log.Crit("Failed to init rule evaluator", "err", err)
+			return nil, fmt.Errorf("failed to init rule evaluator: %v", err)
+		}
+		if err := ruleEngine.Init(string(config.Rules)); err != nil {
+			return nil, fmt.Errorf("failed to init rules: %v", err)
 		}
-		ruleEngine.Init(string(config.Rules))
 		ui = ruleEngine
 	}
 	if config.Accounts != nil {
@@ -91,6 +159,10 @@ func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 		}
 	}
 
+	// Observe all the decisions made by the rules or the user.
+	observed := &observedUI{UIClientAPI: ui}
+	ui = observed
+
 	am := core.StartClefAccountManager(config.Keystore, true, true, "") // Light KDF = true
 	apiImpl := core.NewSignerAPI(am, config.ChainID, true, ui, fbdb, true, pwStorage)
 
@@ -107,22 +179,99 @@ func NewClefDaemon(config *ClefConfig) (*ClefDaemon, error) {
 	ipcapiURL := filepath.Join(config.Dir, "clef.ipc")
 	listener, rpcServer, err := rpc.StartIPCEndpoint(ipcapiURL, rpcAPI)
 	if err != nil {
-		log.Crit("Could not start IPC api", "err", err)
+		am.Close()
+		return nil, fmt.Errorf("could not start IPC api: %v", err)
 	}
 	log.Info("IPC endpoint opened", "url", ipcapiURL)
-	return &ClefDaemon{
+
+	daemon := &ClefDaemon{
 		config:   config,
 		listener: listener,
 		server:   rpcServer,
 		rpcURL:   ipcapiURL,
 		ui:       ui,
-	}, nil
+		am:       am,
+		observed: observed,
+	}
+	if config.HTTPEnabled {
+		server, addr, err := startHTTPServer(config.HTTPPort, rpcServer)
+		if err != nil {
+			daemon.Stop()
+			return nil, err
+		}
+		daemon.httpServer, daemon.httpURL = server, fmt.Sprintf("http://%s", addr)
+		log.Info("HTTP endpoint opened", "url", daemon.httpURL)
+	}
+	if config.WSEnabled {
+		server, addr, err := startHTTPServer(config.WSPort, rpcServer.WebsocketHandler([]string{"*"}))
+		if err != nil {
+			daemon.Stop()
+			return nil, err
+		}
+		daemon.wsServer, daemon.wsURL = server, fmt.Sprintf("ws://%s", addr)
+		log.Info("WebSocket endpoint opened", "url", daemon.wsURL)
+	}
+	return daemon, nil
+}
+
+// startHTTPServer starts a http server on the localhost with the given port
+// and handler, the real listening address is returned.
+func startHTTPServer(port int, handler http.Handler) (*http.Server, string, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, "", err
+	}
+	server := &http.Server{Handler: handler}
+	go server.Serve(listener)
+	return server, listener.Addr().String(), nil
 }
 
+// Stop closes all the endpoints and the account manager, it's safe to be
+// called multiple times.
 func (c *ClefDaemon) Stop() {
-	c.listener.Close()
+	c.stopOnce.Do(func() {
+		c.listener.Close()
+		if c.httpServer != nil {
+			c.httpServer.Close()
+		}
+		if c.wsServer != nil {
+			c.wsServer.Close()
+		}
+		c.am.Close()
+	})
 }
 
+// URL returns the endpoint of the signer with the given transport. The IPC
+// endpoint is returned if the transport is unknown or not enabled, the cluster
+// rejects such transports when it's created.
+func (c *ClefDaemon) URL(transport string) string {
+	switch {
+	case transport == "http" && c.httpURL != "":
+		return c.httpURL
+	case transport == "ws" && c.wsURL != "":
+		return c.wsURL
+	default:
+		return c.rpcURL
+	}
+}
+
+// SubscribeDecisions subscribes to the decisions made by the signer for all
+// the transaction and data signing requests.
+func (c *ClefDaemon) SubscribeDecisions(ch chan<- *SignerDecision) event.Subscription {
+	return c.observed.feed.Subscribe(ch)
+}
+
+// RPCURL returns the IPC endpoint of the signer.
 func (c *ClefDaemon) RPCURL() string {
 	return c.rpcURL
 }
+
+// HTTPURL returns the HTTP endpoint of the signer, empty if it's disabled.
+func (c *ClefDaemon) HTTPURL() string {
+	return c.httpURL
+}
+
+// WSURL returns the WebSocket endpoint of the signer, empty if it's disabled.
+func (c *ClefDaemon) WSURL() string {
+	return c.wsURL
+}
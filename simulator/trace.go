@@ -0,0 +1,212 @@
+package simulator
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// TraceNodesFile is the file name in the trace directory which contains the
+// mapping from node names to node IDs.
+const TraceNodesFile = "nodes.json"
+
+// TraceRecord is a single protocol message exchanged between two nodes. The
+// message is recorded by both the sender and the receiver if both of them
+// are traced.
+type TraceRecord struct {
+	Time     time.Time `json:"time"`
+	Node     string    `json:"node"` // Name of the node which records the message
+	Sender   enode.ID  `json:"sender"`
+	Receiver enode.ID  `json:"receiver"`
+	Protocol string    `json:"protocol"`
+	Version  uint      `json:"version"`
+	Code     uint64    `json:"code"`
+	ReqID    *uint64   `json:"reqid,omitempty"` // Nil if the message is not a LES request or reply
+	Size     uint32    `json:"size"`
+	Received bool      `json:"received"` // Whether the message is recorded by the receiver
+}
+
+// Tracer records all the protocol messages of a node into the trace file, one
+// JSON encoded TraceRecord per line.
+type Tracer struct {
+	name   string
+	lock   sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	enc    *json.Encoder
+}
+
+// NewTracer creates the tracer which writes the trace of the named node into
+// the given file. The records are appended to the existing file, so that the
+// trace is kept across the node restarts.
+func NewTracer(name string, path string) (*Tracer, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	writer := bufio.NewWriter(file)
+	return &Tracer{
+		name:   name,
+		file:   file,
+		writer: writer,
+		enc:    json.NewEncoder(writer),
+	}, nil
+}
+
+// Start implements node.Lifecycle, it's a noop.
+func (t *Tracer) Start() error { return nil }
+
+// Stop implements node.Lifecycle, it flushes and closes the trace file.
+func (t *Tracer) Stop() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if err := t.writer.Flush(); err != nil {
+		t.file.Close()
+		return err
+	}
+	return t.file.Close()
+}
+
+func (t *Tracer) record(r *TraceRecord) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	r.Node = t.name
+	if err := t.enc.Encode(r); err != nil {
+		log.Warn("Failed to write trace", "err", err)
+	}
+}
+
+// Wrap wraps all the protocols registered in the given node so that all the
+// messages are recorded. It must be called after all the protocols are
+// registered but before the node is started. The tracer is registered as the
+// lifecycle of the node so that the trace file is closed with the node.
+func (t *Tracer) Wrap(stack *node.Node) {
+	srv := stack.Server()
+	for i := range srv.Protocols {
+		proto := &srv.Protocols[i]
+		run, name, version := proto.Run, proto.Name, proto.Version
+		proto.Run = func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+			return run(p, &traceRW{
+				MsgReadWriter: rw,
+				tracer:        t,
+				local:         srv.Self().ID(),
+				remote:        p.ID(),
+				protocol:      name,
+				version:       version,
+			})
+		}
+	}
+	stack.RegisterLifecycle(t)
+}
+
+// traceRW is the wrapped message read writer which records all the messages.
+type traceRW struct {
+	p2p.MsgReadWriter
+	tracer   *Tracer
+	local    enode.ID
+	remote   enode.ID
+	protocol string
+	version  uint
+}
+
+func (rw *traceRW) ReadMsg() (p2p.Msg, error) {
+	msg, err := rw.MsgReadWriter.ReadMsg()
+	if err != nil {
+		return msg, err
+	}
+	reqID, err := rw.peek(&msg)
+	if err != nil {
+		return msg, err
+	}
+	rw.tracer.record(&TraceRecord{
+		Time:     msg.ReceivedAt,
+		Sender:   rw.remote,
+		Receiver: rw.local,
+		Protocol: rw.protocol,
+		Version:  rw.version,
+		Code:     msg.Code,
+		ReqID:    reqID,
+		Size:     msg.Size,
+		Received: true,
+	})
+	return msg, nil
+}
+
+func (rw *traceRW) WriteMsg(msg p2p.Msg) error {
+	reqID, err := rw.peek(&msg)
+	if err != nil {
+		return err
+	}
+	rw.tracer.record(&TraceRecord{
+		Time:     time.Now(),
+		Sender:   rw.local,
+		Receiver: rw.remote,
+		Protocol: rw.protocol,
+		Version:  rw.version,
+		Code:     msg.Code,
+		ReqID:    reqID,
+		Size:     msg.Size,
+	})
+	return rw.MsgReadWriter.WriteMsg(msg)
+}
+
+// peek extracts the request id from the LES request or reply message without
+// consuming the payload. Nil is returned if the message doesn't carry a request
+// id, e.g. the status, announcement and flow control messages.
+func (rw *traceRW) peek(msg *p2p.Msg) (*uint64, error) {
+	if rw.protocol != "les" || !(isLesRequest(msg.Code) || isLesReply(msg.Code)) {
+		return nil, nil
+	}
+	payload, err := ioutil.ReadAll(io.LimitReader(msg.Payload, int64(msg.Size)))
+	if err != nil {
+		return nil, err
+	}
+	msg.Payload = bytes.NewReader(payload)
+
+	content, _, err := rlp.SplitList(payload)
+	if err != nil {
+		return nil, nil
+	}
+	var reqID uint64
+	if err := rlp.NewStream(bytes.NewReader(content), uint64(len(content))).Decode(&reqID); err != nil {
+		return nil, nil
+	}
+	return &reqID, nil
+}
+
+// ReadTrace reads all the records from the given trace file.
+func ReadTrace(path string) ([]*TraceRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var (
+		records []*TraceRecord
+		dec     = json.NewDecoder(file)
+	)
+	for {
+		var r TraceRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, &r)
+	}
+	return records, nil
+}
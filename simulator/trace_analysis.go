@@ -0,0 +1,404 @@
+package simulator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p/enode"
+)
+
+// lesMessages is the names of the LES protocol messages.
+var lesMessages = map[uint64]string{
+	0x00: "Status",
+	0x01: "Announce",
+	0x02: "GetBlockHeaders",
+	0x03: "BlockHeaders",
+	0x04: "GetBlockBodies",
+	0x05: "BlockBodies",
+	0x06: "GetReceipts",
+	0x07: "Receipts",
+	0x0a: "GetCode",
+	0x0b: "Code",
+	0x0f: "GetProofsV2",
+	0x10: "ProofsV2",
+	0x11: "GetHelperTrieProofs",
+	0x12: "HelperTrieProofs",
+	0x13: "SendTxV2",
+	0x14: "GetTxStatus",
+	0x15: "TxStatus",
+	0x16: "Stop",
+	0x17: "Resume",
+}
+
+// ethMessages is the names of the eth protocol messages.
+var ethMessages = map[uint64]string{
+	0x00: "Status",
+	0x01: "NewBlockHashes",
+	0x02: "Transactions",
+	0x03: "GetBlockHeaders",
+	0x04: "BlockHeaders",
+	0x05: "GetBlockBodies",
+	0x06: "BlockBodies",
+	0x07: "NewBlock",
+	0x08: "NewPooledTransactionHashes",
+	0x09: "GetPooledTransactions",
+	0x0a: "PooledTransactions",
+	0x0d: "GetNodeData",
+	0x0e: "NodeData",
+	0x0f: "GetReceipts",
+	0x10: "Receipts",
+}
+
+// MessageName returns the human readable name of the protocol message.
+func MessageName(protocol string, code uint64) string {
+	var names map[uint64]string
+	switch protocol {
+	case "les":
+		names = lesMessages
+	case "eth":
+		names = ethMessages
+	}
+	if name, ok := names[code]; ok {
+		return fmt.Sprintf("%s/%s", protocol, name)
+	}
+	return fmt.Sprintf("%s/0x%02x", protocol, code)
+}
+
+// Trace is the protocol message trace of a cluster run.
+type Trace struct {
+	Nodes   map[string]enode.ID       // Mapping from node names to IDs, empty if unknown
+	Records map[string][]*TraceRecord // Records keyed by the name of recording node
+
+	names map[enode.ID]string
+}
+
+// LoadTrace loads all the node traces in the given trace directory.
+func LoadTrace(dir string) (*Trace, error) {
+	trace := &Trace{
+		Nodes:   make(map[string]enode.ID),
+		Records: make(map[string][]*TraceRecord),
+		names:   make(map[enode.ID]string),
+	}
+	blob, err := ioutil.ReadFile(filepath.Join(dir, TraceNodesFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(blob, &trace.Nodes); err != nil {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+	for name, id := range trace.Nodes {
+		trace.names[id] = name
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.trace"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no trace in %s", dir)
+	}
+	for _, file := range files {
+		records, err := ReadTrace(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		trace.Records[strings.TrimSuffix(filepath.Base(file), ".trace")] = records
+	}
+	return trace, nil
+}
+
+// Name returns the node name of the given ID, the abbreviated ID is returned
+// if the node is unknown.
+func (t *Trace) Name(id enode.ID) string {
+	if name, ok := t.names[id]; ok {
+		return name
+	}
+	return id.TerminalString()
+}
+
+// messages returns all the messages in the trace, each message is only
+// returned once even if it's recorded by both the sender and the receiver.
+func (t *Trace) messages() []*TraceRecord {
+	var list []*TraceRecord
+	for _, records := range t.Records {
+		for _, r := range records {
+			// Prefer the record written by the sender, the one written by
+			// receiver is only used if the sender is not traced.
+			if r.Received {
+				if _, traced := t.Records[t.Name(r.Sender)]; traced {
+					continue
+				}
+			}
+			list = append(list, r)
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Time.Before(list[j].Time) })
+	return list
+}
+
+// MessageStat is the statistics of a single message type.
+type MessageStat struct {
+	Name  string
+	Count int
+	Bytes uint64
+}
+
+// LatencyStat is the latency statistics of a single request type, measured
+// from sending the request to receiving the reply by the requester.
+type LatencyStat struct {
+	Name  string
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// PeerStat is the traffic statistics from one node to another.
+type PeerStat struct {
+	From       string
+	To         string
+	Count      int
+	Bytes      uint64
+	Throughput float64 // Bytes per second over the whole trace
+}
+
+// TraceSummary is the summary of a trace.
+type TraceSummary struct {
+	Start     time.Time
+	End       time.Time
+	Messages  []*MessageStat
+	Latencies []*LatencyStat
+	Peers     []*PeerStat
+}
+
+// Summarize computes the message counts per type, request latency
+// percentiles and the throughput per peer pair.
+func (t *Trace) Summarize() *TraceSummary {
+	var (
+		summary  = &TraceSummary{}
+		messages = make(map[string]*MessageStat)
+		peers    = make(map[[2]string]*PeerStat)
+	)
+	all := t.messages()
+	if len(all) > 0 {
+		summary.Start, summary.End = all[0].Time, all[len(all)-1].Time
+	}
+	for _, r := range all {
+		name := MessageName(r.Protocol, r.Code)
+		stat, ok := messages[name]
+		if !ok {
+			stat = &MessageStat{Name: name}
+			messages[name] = stat
+		}
+		stat.Count++
+		stat.Bytes += uint64(r.Size)
+
+		key := [2]string{t.Name(r.Sender), t.Name(r.Receiver)}
+		peer, ok := peers[key]
+		if !ok {
+			peer = &PeerStat{From: key[0], To: key[1]}
+			peers[key] = peer
+		}
+		peer.Count++
+		peer.Bytes += uint64(r.Size)
+	}
+	for _, stat := range messages {
+		summary.Messages = append(summary.Messages, stat)
+	}
+	sort.Slice(summary.Messages, func(i, j int) bool { return summary.Messages[i].Name < summary.Messages[j].Name })
+
+	duration := summary.End.Sub(summary.Start).Seconds()
+	for _, peer := range peers {
+		if duration > 0 {
+			peer.Throughput = float64(peer.Bytes) / duration
+		}
+		summary.Peers = append(summary.Peers, peer)
+	}
+	sort.Slice(summary.Peers, func(i, j int) bool {
+		if summary.Peers[i].From != summary.Peers[j].From {
+			return summary.Peers[i].From < summary.Peers[j].From
+		}
+		return summary.Peers[i].To < summary.Peers[j].To
+	})
+	summary.Latencies = t.latencies()
+	return summary
+}
+
+// latencies matches the requests and replies by request id on the requester
+// side and computes the latency percentiles per request type.
+func (t *Trace) latencies() []*LatencyStat {
+	type pending struct {
+		name string
+		time time.Time
+	}
+	samples := make(map[string][]time.Duration)
+	for _, records := range t.Records {
+		requests := make(map[enode.ID]map[uint64]*pending)
+		for _, r := range records {
+			if r.ReqID == nil {
+				continue
+			}
+			if !r.Received {
+				if requests[r.Receiver] == nil {
+					requests[r.Receiver] = make(map[uint64]*pending)
+				}
+				requests[r.Receiver][*r.ReqID] = &pending{name: MessageName(r.Protocol, r.Code), time: r.Time}
+				continue
+			}
+			req, ok := requests[r.Sender][*r.ReqID]
+			if !ok {
+				continue // It's the request sent by the remote peer
+			}
+			delete(requests[r.Sender], *r.ReqID)
+			samples[req.name] = append(samples[req.name], r.Time.Sub(req.time))
+		}
+	}
+	var stats []*LatencyStat
+	for name, list := range samples {
+		sort.Slice(list, func(i, j int) bool { return list[i] < list[j] })
+		stats = append(stats, &LatencyStat{
+			Name:  name,
+			Count: len(list),
+			P50:   Percentile(list, 50),
+			P90:   Percentile(list, 90),
+			P99:   Percentile(list, 99),
+			Max:   list[len(list)-1],
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Percentile returns the nearest-rank percentile of the sorted list.
+func Percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// TraceDivergence is the first difference found between two traces.
+type TraceDivergence struct {
+	Node  string       // Name of the recording node
+	Peer  string       // Name of the remote node
+	Index int          // Index of the message in the stream between two nodes
+	A     *TraceRecord // Nil if the stream in trace A is shorter
+	B     *TraceRecord // Nil if the stream in trace B is shorter
+}
+
+func (d *TraceDivergence) String() string {
+	describe := func(r *TraceRecord) string {
+		if r == nil {
+			return "<none>"
+		}
+		dir := "sent"
+		if r.Received {
+			dir = "received"
+		}
+		return fmt.Sprintf("%s %s size=%d at %v", dir, MessageName(r.Protocol, r.Code), r.Size, r.Time.Format(time.RFC3339Nano))
+	}
+	return fmt.Sprintf("%s<->%s message #%d: A %s, B %s", d.Node, d.Peer, d.Index, describe(d.A), describe(d.B))
+}
+
+// DiffTraces compares two traces of the runs with the same setup and returns
+// the first divergence, nil if they are identical. Nodes are matched by name
+// since the node IDs differ between runs. The messages are compared per node
+// pair by direction, protocol and message code, the request ids and
+// timestamps are ignored since they are random. If sizes is set, message
+// sizes are compared as well.
+func DiffTraces(a, b *Trace, sizes bool) *TraceDivergence {
+	streamsA, streamsB := a.streams(), b.streams()
+
+	var keys [][2]string
+	seen := make(map[[2]string]bool)
+	for _, streams := range []map[[2]string][]*TraceRecord{streamsA, streamsB} {
+		for key := range streams {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	// Iterate the streams in a stable order, so that the first one wins if
+	// multiple divergences happen at the same time.
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return NodeLess(keys[i][0], keys[j][0])
+		}
+		return NodeLess(keys[i][1], keys[j][1])
+	})
+	var first *TraceDivergence
+	for _, key := range keys {
+		sa, sb := streamsA[key], streamsB[key]
+		for i := 0; i < len(sa) || i < len(sb); i++ {
+			var ra, rb *TraceRecord
+			if i < len(sa) {
+				ra = sa[i]
+			}
+			if i < len(sb) {
+				rb = sb[i]
+			}
+			if ra != nil && rb != nil && sameMessage(ra, rb, sizes) {
+				continue
+			}
+			d := &TraceDivergence{Node: key[0], Peer: key[1], Index: i, A: ra, B: rb}
+			if first == nil || d.offset(a, b) < first.offset(a, b) {
+				first = d
+			}
+			break
+		}
+	}
+	return first
+}
+
+// offset returns the time elapsed since the beginning of the trace when the
+// divergence happens, it's used to find the earliest divergence.
+func (d *TraceDivergence) offset(a, b *Trace) time.Duration {
+	if d.A != nil {
+		return d.A.Time.Sub(a.start())
+	}
+	return d.B.Time.Sub(b.start())
+}
+
+// start returns the time of the first record in the trace.
+func (t *Trace) start() time.Time {
+	var start time.Time
+	for _, records := range t.Records {
+		if len(records) > 0 && (start.IsZero() || records[0].Time.Before(start)) {
+			start = records[0].Time
+		}
+	}
+	return start
+}
+
+// streams groups the records by recording node and remote node names.
+func (t *Trace) streams() map[[2]string][]*TraceRecord {
+	streams := make(map[[2]string][]*TraceRecord)
+	for node, records := range t.Records {
+		for _, r := range records {
+			remote := r.Receiver
+			if r.Received {
+				remote = r.Sender
+			}
+			key := [2]string{node, t.Name(remote)}
+			streams[key] = append(streams[key], r)
+		}
+	}
+	return streams
+}
+
+func sameMessage(a, b *TraceRecord, sizes bool) bool {
+	if a.Received != b.Received || a.Protocol != b.Protocol || a.Code != b.Code {
+		return false
+	}
+	return !sizes || a.Size == b.Size
+}
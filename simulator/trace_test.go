@@ -0,0 +1,141 @@
+package simulator
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/enode"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestTraceRW(t *testing.T) {
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir, err %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		path          = filepath.Join(dir, "c0.trace")
+		local, remote = enode.ID{0x01}, enode.ID{0x02}
+	)
+	type request struct {
+		ReqID  uint64
+		Amount uint64
+	}
+	// send sends the message via a new tracer, which is closed afterwards.
+	send := func(code uint64, data interface{}) {
+		tracer, err := NewTracer("c0", path)
+		if err != nil {
+			t.Fatalf("Failed to create tracer, err %v", err)
+		}
+		in, out := p2p.MsgPipe()
+		defer in.Close()
+		rw := &traceRW{MsgReadWriter: in, tracer: tracer, local: local, remote: remote, protocol: "les", version: 3}
+
+		go p2p.Send(rw, code, data)
+		msg, err := out.ReadMsg()
+		if err != nil {
+			t.Fatalf("Failed to read message, err %v", err)
+		}
+		sent, _ := rlp.EncodeToBytes(data)
+		received, _ := ioutil.ReadAll(msg.Payload)
+		if !bytes.Equal(sent, received) {
+			t.Fatalf("Payload is corrupted, want %x, got %x", sent, received)
+		}
+		tracer.Stop()
+	}
+	send(0x02, &request{ReqID: 42, Amount: 1}) // GetBlockHeadersMsg
+	send(0x01, &request{ReqID: 43, Amount: 2}) // AnnounceMsg
+	send(0x16, []interface{}{})                // StopMsg
+	send(0x17, uint64(44))                     // ResumeMsg
+
+	// The records are appended across the tracers
+	records, err := ReadTrace(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace, err %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Unexpected record number, want 4, got %d", len(records))
+	}
+	first := records[0]
+	if first.Node != "c0" || first.Sender != local || first.Receiver != remote || first.Code != 0x02 || first.Received {
+		t.Fatalf("Unexpected record %+v", first)
+	}
+	if first.ReqID == nil || *first.ReqID != 42 {
+		t.Fatalf("Unexpected request id %v", first.ReqID)
+	}
+	for _, record := range records[1:] {
+		if record.ReqID != nil {
+			t.Fatalf("Message %s should have no request id", MessageName(record.Protocol, record.Code))
+		}
+	}
+}
+
+// newTestTrace creates a trace of the single request-reply exchange between
+// c0 and s0, the reply code can be customized.
+func newTestTrace(client, server enode.ID, replyCode uint64) *Trace {
+	var (
+		start = time.Unix(1000, 0)
+		reqID = uint64(1)
+	)
+	return &Trace{
+		Nodes: map[string]enode.ID{"c0": client, "s0": server},
+		Records: map[string][]*TraceRecord{
+			"c0": {
+				{Time: start, Node: "c0", Sender: client, Receiver: server, Protocol: "les", Code: 0x02, ReqID: &reqID, Size: 10},
+				{Time: start.Add(20 * time.Millisecond), Node: "c0", Sender: server, Receiver: client, Protocol: "les", Code: replyCode, ReqID: &reqID, Size: 100, Received: true},
+			},
+			"s0": {
+				{Time: start.Add(5 * time.Millisecond), Node: "s0", Sender: client, Receiver: server, Protocol: "les", Code: 0x02, ReqID: &reqID, Size: 10, Received: true},
+				{Time: start.Add(10 * time.Millisecond), Node: "s0", Sender: server, Receiver: client, Protocol: "les", Code: replyCode, ReqID: &reqID, Size: 100},
+			},
+		},
+		names: map[enode.ID]string{client: "c0", server: "s0"},
+	}
+}
+
+func TestTraceSummary(t *testing.T) {
+	summary := newTestTrace(enode.ID{0x01}, enode.ID{0x02}, 0x03).Summarize()
+	if len(summary.Messages) != 2 {
+		t.Fatalf("Unexpected message types, want 2, got %d", len(summary.Messages))
+	}
+	if m := summary.Messages[0]; m.Name != "les/BlockHeaders" || m.Count != 1 || m.Bytes != 100 {
+		t.Fatalf("Unexpected message stat %+v", m)
+	}
+	if len(summary.Latencies) != 1 || summary.Latencies[0].P50 != 20*time.Millisecond {
+		t.Fatalf("Unexpected latencies %+v", summary.Latencies)
+	}
+	if len(summary.Peers) != 2 || summary.Peers[0].From != "c0" || summary.Peers[0].Bytes != 10 {
+		t.Fatalf("Unexpected peer stats %+v", summary.Peers)
+	}
+}
+
+func TestDiffTraces(t *testing.T) {
+	a := newTestTrace(enode.ID{0x01}, enode.ID{0x02}, 0x03)
+	if d := DiffTraces(a, newTestTrace(enode.ID{0x03}, enode.ID{0x04}, 0x03), true); d != nil {
+		t.Fatalf("Unexpected divergence %v", d)
+	}
+	d := DiffTraces(a, newTestTrace(enode.ID{0x03}, enode.ID{0x04}, 0x05), true)
+	if d == nil {
+		t.Fatalf("Divergence is not detected")
+	}
+	if d.Node != "s0" || d.Peer != "c0" || d.Index != 1 {
+		t.Fatalf("Unexpected divergence %v", d)
+	}
+
+	// The divergences at the same time are reported in the stable order
+	for i := 0; i < 20; i++ {
+		a, b := newTestTrace(enode.ID{0x01}, enode.ID{0x02}, 0x03), newTestTrace(enode.ID{0x03}, enode.ID{0x04}, 0x05)
+		a.Records["s0"][1].Time = a.Records["c0"][1].Time
+		b.Records["s0"][1].Time = b.Records["c0"][1].Time
+		if d := DiffTraces(a, b, true); d == nil || d.Node != "s0" || d.Peer != "c0" {
+			t.Fatalf("Unexpected divergence %v", d)
+		}
+	}
+}
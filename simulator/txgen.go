@@ -0,0 +1,327 @@
+package simulator
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// CounterAddress is the address of the counter contract deployed in genesis
+// when the transaction generator is configured. Any call to the contract
+// increments the counter in slot 0 and emits the new value as a log.
+var CounterAddress = common.HexToAddress("0x000000000000000000000000000000000000c0de")
+
+// counterCode is the runtime code of the counter contract:
+//
+//	v := add(sload(0), 1); sstore(0, v); mstore(0, v); log0(0, 32)
+var counterCode = common.Hex2Bytes("6000546001018060005560005260206000a000")
+
+const (
+	// txCallGas is the gas limit of the counter contract calls.
+	txCallGas = 60000
+
+	// txSendTimeout is the maximum time for submitting a single transaction.
+	txSendTimeout = 5 * time.Second
+
+	// maxTxInFlight is the maximum number of the pending submissions, the
+	// transaction exceeding the limit is counted as failed.
+	maxTxInFlight = 256
+
+	// maxTPS is the maximum transaction rate. The transactions are signed
+	// one by one in the generator loop, and the txpool of a server holds at
+	// most 5120 transactions by default, so a higher rate only fills up the
+	// pools within seconds.
+	maxTPS = 1000
+)
+
+// TxConfig contains the settings of the transaction generator, which feeds the
+// transfers and contract calls from the prefunded accounts into the txpools
+// of the servers.
+type TxConfig struct {
+	// TPS is the total number of transactions per second, at most 1000.
+	TPS float64
+
+	// Accounts is the number of the prefunded sender accounts, the transactions
+	// are sent from them in turn.
+	//
+	// The default value is 0 which means a single account is used.
+	Accounts int
+
+	// CallRatio is the fraction of counter contract calls in the transactions,
+	// the rest are the plain transfers between the sender accounts.
+	CallRatio float64
+
+	// Servers is the list of indexes of the servers to receive transactions,
+	// the transactions are sent to them in turn.
+	//
+	// The default value is nil which means all the servers are used.
+	Servers []int
+}
+
+// validate checks the settings of the transaction generator.
+func (config *TxConfig) validate() error {
+	if config.TPS <= 0 || config.TPS > maxTPS {
+		return errors.New("invalid transaction rate")
+	}
+	return nil
+}
+
+// txAccount is the sender account of the transaction generator.
+type txAccount struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+	nonce   *uint64 // Next nonce, nil if it should be fetched from the server
+	stale   uint32  // Whether a submission failed and the nonce should be re-fetched (atomic)
+}
+
+// txGenerator sends transactions into the servers at the configured rate.
+type txGenerator struct {
+	config   *TxConfig
+	signer   types.Signer
+	accounts []*txAccount
+
+	sent   uint64 // Number of the submitted transactions (atomic)
+	failed uint64 // Number of the failed submissions (atomic)
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTxGenerator(config *TxConfig, chainConfig *params.ChainConfig) *txGenerator {
+	n := config.Accounts
+	if n <= 0 {
+		n = 1
+	}
+	gen := &txGenerator{
+		config: config,
+		signer: types.NewEIP155Signer(chainConfig.ChainID),
+	}
+	// Derive the sender keys deterministically from the master key so that
+	// the genesis is the same in all the nodes.
+	seed := common.Hex2Bytes(masterKeyPrivate)
+	for i := 0; i < n; i++ {
+		var index [8]byte
+		binary.BigEndian.PutUint64(index[:], uint64(i))
+		key, _ := crypto.ToECDSA(crypto.Keccak256(seed, index[:]))
+		gen.accounts = append(gen.accounts, &txAccount{key: key, address: crypto.PubkeyToAddress(key.PublicKey)})
+	}
+	return gen
+}
+
+// alloc adds the sender accounts and the counter contract into the genesis.
+func (gen *txGenerator) alloc(alloc core.GenesisAlloc) {
+	fund := new(big.Int).Mul(big.NewInt(1000), big.NewInt(params.Ether))
+	for _, account := range gen.accounts {
+		alloc[account.address] = core.GenesisAccount{Balance: fund}
+	}
+	alloc[CounterAddress] = core.GenesisAccount{Balance: new(big.Int), Code: counterCode}
+}
+
+// start launches the generator sending transactions to the given servers.
+func (gen *txGenerator) start(servers []*ethclient.Client) {
+	gen.quit = make(chan struct{})
+	gen.wg.Add(1)
+	go gen.loop(servers)
+}
+
+// stop terminates the generator and waits the pending submissions.
+func (gen *txGenerator) stop() {
+	close(gen.quit)
+	gen.wg.Wait()
+	gen.quit = nil
+}
+
+// loop sends the transactions at the configured rate. The transactions are
+// signed in turn, which keeps the nonces of each account in order, but are
+// submitted concurrently so that the rate doesn't depend on the latency of
+// the servers.
+func (gen *txGenerator) loop(servers []*ethclient.Client) {
+	defer gen.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / gen.config.TPS))
+	defer ticker.Stop()
+
+	var (
+		wg       sync.WaitGroup
+		inflight = make(chan struct{}, maxTxInFlight)
+		count    int
+		calls    float64 // Accumulated fraction of contract calls
+	)
+	for {
+		select {
+		case <-ticker.C:
+			var (
+				account = gen.accounts[count%len(gen.accounts)]
+				server  = servers[count%len(servers)]
+				to      = gen.accounts[(count+1)%len(gen.accounts)].address
+				call    bool
+			)
+			count++
+			calls += gen.config.CallRatio
+			if calls >= 1 {
+				calls -= 1
+				call = true
+			}
+			select {
+			case inflight <- struct{}{}:
+			default:
+				atomic.AddUint64(&gen.failed, 1)
+				log.Debug("Too many transactions in flight", "limit", maxTxInFlight)
+				continue
+			}
+			tx, err := gen.prepare(server, account, to, call)
+			if err != nil {
+				<-inflight
+				atomic.AddUint64(&gen.failed, 1)
+				log.Debug("Failed to prepare transaction", "from", account.address, "err", err)
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-inflight
+					wg.Done()
+				}()
+				if err := gen.submit(server, account, tx); err != nil {
+					atomic.AddUint64(&gen.failed, 1)
+					log.Debug("Failed to send transaction", "from", account.address, "err", err)
+				} else {
+					atomic.AddUint64(&gen.sent, 1)
+				}
+			}()
+		case <-gen.quit:
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// send prepares and submits a transfer or a counter contract call from the
+// account into the server.
+func (gen *txGenerator) send(server *ethclient.Client, account *txAccount, to common.Address, call bool) error {
+	tx, err := gen.prepare(server, account, to, call)
+	if err != nil {
+		return err
+	}
+	return gen.submit(server, account, tx)
+}
+
+// prepare creates and signs a transfer or a counter contract call from the
+// account with the next local nonce. The nonce is fetched from the server if
+// it's unknown or a previous submission failed. It must not be called
+// concurrently for the same account.
+func (gen *txGenerator) prepare(server *ethclient.Client, account *txAccount, to common.Address, call bool) (*types.Transaction, error) {
+	if account.nonce == nil || atomic.CompareAndSwapUint32(&account.stale, 1, 0) {
+		ctx, cancel := context.WithTimeout(context.Background(), txSendTimeout)
+		nonce, err := server.PendingNonceAt(ctx, account.address)
+		cancel()
+		if err != nil {
+			account.nonce = nil
+			return nil, err
+		}
+		account.nonce = &nonce
+	}
+	var tx *types.Transaction
+	if call {
+		tx = types.NewTransaction(*account.nonce, CounterAddress, new(big.Int), txCallGas, big.NewInt(2*params.GWei), nil)
+	} else {
+		tx = types.NewTransaction(*account.nonce, to, big.NewInt(1), params.TxGas, big.NewInt(2*params.GWei), nil)
+	}
+	signed, err := types.SignTx(tx, gen.signer, account.key)
+	if err != nil {
+		return nil, err
+	}
+	*account.nonce++
+	return signed, nil
+}
+
+// submit sends the signed transaction into the server. The account is marked
+// stale if the submission fails, so that its nonce is re-fetched from the
+// server next time.
+func (gen *txGenerator) submit(server *ethclient.Client, account *txAccount, tx *types.Transaction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), txSendTimeout)
+	defer cancel()
+
+	if err := server.SendTransaction(ctx, tx); err != nil {
+		atomic.StoreUint32(&account.stale, 1)
+		return err
+	}
+	return nil
+}
+
+// TxAccounts returns the sender accounts of the transaction generator, nil if
+// the generator is not configured.
+func (cluster *Cluster) TxAccounts() []common.Address {
+	if cluster.txgen == nil {
+		return nil
+	}
+	var accounts []common.Address
+	for _, account := range cluster.txgen.accounts {
+		accounts = append(accounts, account.address)
+	}
+	return accounts
+}
+
+// StartTransactions starts feeding the transactions into the servers with
+// the configured `ClusterConfig.Transactions`. The servers must be running.
+func (cluster *Cluster) StartTransactions() error {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
+	if cluster.txgen == nil {
+		return errors.New("transaction generator is not configured")
+	}
+	if cluster.txgen.quit != nil {
+		return errors.New("transaction generator is already running")
+	}
+	indexes := cluster.txgen.config.Servers
+	if indexes == nil {
+		for i := range cluster.servers {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return errors.New("no server to receive transactions")
+	}
+	var servers []*ethclient.Client
+	for _, index := range indexes {
+		if index < 0 || index >= len(cluster.servers) {
+			return errors.New("invalid server index")
+		}
+		client, err := cluster.servers[index].node.Client()
+		if err != nil {
+			return &NodeError{Node: serverName(index), Err: err}
+		}
+		servers = append(servers, ethclient.NewClient(client))
+	}
+	cluster.txgen.start(servers)
+	return nil
+}
+
+// StopTransactions stops the transaction generator and returns the number of
+// the submitted and failed transactions.
+func (cluster *Cluster) StopTransactions() (sent uint64, failed uint64) {
+	cluster.lock.Lock()
+	defer cluster.lock.Unlock()
+
+	if cluster.txgen == nil {
+		return 0, 0
+	}
+	if cluster.txgen.quit != nil {
+		cluster.txgen.stop()
+	}
+	return atomic.LoadUint64(&cluster.txgen.sent), atomic.LoadUint64(&cluster.txgen.failed)
+}
@@ -0,0 +1,180 @@
+package simulator
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func TestTxGeneratorAlloc(t *testing.T) {
+	var (
+		a     = newTxGenerator(&TxConfig{TPS: 1, Accounts: 3}, params.AllEthashProtocolChanges)
+		b     = newTxGenerator(&TxConfig{TPS: 1, Accounts: 3}, params.AllEthashProtocolChanges)
+		alloc = make(core.GenesisAlloc)
+	)
+	if len(a.accounts) != 3 {
+		t.Fatalf("Unexpected account number, want 3, got %d", len(a.accounts))
+	}
+	for i := range a.accounts {
+		if a.accounts[i].address != b.accounts[i].address {
+			t.Fatalf("Account %d is not deterministic", i)
+		}
+	}
+	a.alloc(alloc)
+	if len(alloc) != 4 {
+		t.Fatalf("Unexpected alloc size, want 4, got %d", len(alloc))
+	}
+	if !bytes.Equal(alloc[CounterAddress].Code, counterCode) {
+		t.Fatalf("Counter contract is not allocated")
+	}
+	if single := newTxGenerator(&TxConfig{TPS: 1}, params.AllEthashProtocolChanges); len(single.accounts) != 1 {
+		t.Fatalf("Unexpected default account number %d", len(single.accounts))
+	}
+}
+
+func TestTxConfigValidate(t *testing.T) {
+	for _, tps := range []float64{0, -1, maxTPS + 1} {
+		if err := (&TxConfig{TPS: tps}).validate(); err == nil {
+			t.Fatalf("TPS %v should be rejected", tps)
+		}
+	}
+	for _, tps := range []float64{0.5, maxTPS} {
+		if err := (&TxConfig{TPS: tps}).validate(); err != nil {
+			t.Fatalf("TPS %v should be accepted, err: %v", tps, err)
+		}
+	}
+}
+
+// testTxPoolAPI is the minimal eth namespace of the server for accepting the
+// transactions, the pending nonce is increased by each accepted transaction.
+type testTxPoolAPI struct {
+	lock    sync.Mutex
+	nonce   uint64               // Pending nonce of the sender
+	reject  bool                 // Whether to reject the next transaction
+	fetches int                  // Number of the pending nonce retrievals
+	txs     []*types.Transaction // Accepted transactions
+}
+
+func (api *testTxPoolAPI) GetTransactionCount(account common.Address, block string) hexutil.Uint64 {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.fetches++
+	return hexutil.Uint64(api.nonce)
+}
+
+func (api *testTxPoolAPI) SendRawTransaction(blob hexutil.Bytes) (common.Hash, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(blob, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if api.reject {
+		api.reject = false
+		return common.Hash{}, errors.New("rejected")
+	}
+	if tx.Nonce() != api.nonce {
+		return common.Hash{}, errors.New("nonce gap")
+	}
+	api.nonce++
+	api.txs = append(api.txs, tx)
+	return tx.Hash(), nil
+}
+
+func TestTxGeneratorSend(t *testing.T) {
+	api := &testTxPoolAPI{nonce: 3}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatalf("Failed to register API: %v", err)
+	}
+	defer server.Stop()
+
+	var (
+		gen     = newTxGenerator(&TxConfig{TPS: 1, Accounts: 2}, params.AllEthashProtocolChanges)
+		client  = ethclient.NewClient(rpc.DialInProc(server))
+		account = gen.accounts[0]
+		to      = gen.accounts[1].address
+	)
+	if err := gen.send(client, account, to, false); err != nil {
+		t.Fatalf("Failed to send transfer: %v", err)
+	}
+	if err := gen.send(client, account, to, true); err != nil {
+		t.Fatalf("Failed to send contract call: %v", err)
+	}
+	// The local nonce is dropped after the failure and re-fetched
+	api.reject = true
+	if err := gen.send(client, account, to, false); err == nil {
+		t.Fatal("Expected rejected transaction")
+	}
+	if atomic.LoadUint32(&account.stale) != 1 {
+		t.Fatalf("Account is not marked stale after failure")
+	}
+	if err := gen.send(client, account, to, false); err != nil {
+		t.Fatalf("Failed to send transfer after failure: %v", err)
+	}
+	if api.fetches != 2 {
+		t.Fatalf("Unexpected nonce retrievals, want 2, got %d", api.fetches)
+	}
+	var nonces []uint64
+	for _, tx := range api.txs {
+		nonces = append(nonces, tx.Nonce())
+	}
+	if want := []uint64{3, 4, 5}; !reflect.DeepEqual(nonces, want) {
+		t.Fatalf("Unexpected nonces, want %v, got %v", want, nonces)
+	}
+	if to := api.txs[0].To(); to == nil || *to != gen.accounts[1].address {
+		t.Fatalf("Unexpected transfer recipient %v", to)
+	}
+	if to := api.txs[1].To(); to == nil || *to != CounterAddress || api.txs[1].Gas() != txCallGas {
+		t.Fatalf("Unexpected contract call %v, gas %d", to, api.txs[1].Gas())
+	}
+}
+
+// testSlowTxPoolAPI is the eth namespace of the server which accepts all the
+// transactions after the given delay.
+type testSlowTxPoolAPI struct {
+	delay time.Duration
+}
+
+func (api *testSlowTxPoolAPI) GetTransactionCount(account common.Address, block string) hexutil.Uint64 {
+	return 0
+}
+
+func (api *testSlowTxPoolAPI) SendRawTransaction(blob hexutil.Bytes) (common.Hash, error) {
+	time.Sleep(api.delay)
+	return common.Hash{}, nil
+}
+
+func TestTxGeneratorRate(t *testing.T) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", &testSlowTxPoolAPI{delay: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("Failed to register API: %v", err)
+	}
+	defer server.Stop()
+
+	// The submissions are concurrent, the rate is reached even if each of
+	// them takes longer than the interval.
+	gen := newTxGenerator(&TxConfig{TPS: 100}, params.AllEthashProtocolChanges)
+	gen.start([]*ethclient.Client{ethclient.NewClient(rpc.DialInProc(server))})
+	time.Sleep(time.Second)
+	gen.stop()
+
+	if sent, failed := atomic.LoadUint64(&gen.sent), atomic.LoadUint64(&gen.failed); sent < 50 || failed != 0 {
+		t.Fatalf("Unexpected submissions, sent %d, failed %d", sent, failed)
+	}
+}
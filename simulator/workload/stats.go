@@ -0,0 +1,67 @@
+package workload
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// Stat is the statistics of a single request type.
+type Stat struct {
+	Op     Op
+	Count  int // Number of requests, including the failed ones
+	Errors int
+	P50    time.Duration // Latency percentiles of the successful requests
+	P90    time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// Stats returns the statistics of the recorded results per request type,
+// sorted by the type.
+func Stats(results []*Result) []*Stat {
+	var (
+		stats     = make(map[Op]*Stat)
+		latencies = make(map[Op][]time.Duration)
+	)
+	for _, r := range results {
+		stat, ok := stats[r.Op]
+		if !ok {
+			stat = &Stat{Op: r.Op}
+			stats[r.Op] = stat
+		}
+		stat.Count++
+		if r.Err != nil {
+			stat.Errors++
+			continue
+		}
+		latencies[r.Op] = append(latencies[r.Op], r.Latency)
+	}
+	var list []*Stat
+	for op, stat := range stats {
+		if samples := latencies[op]; len(samples) > 0 {
+			sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+			stat.P50 = simulator.Percentile(samples, 50)
+			stat.P90 = simulator.Percentile(samples, 90)
+			stat.P99 = simulator.Percentile(samples, 99)
+			stat.Max = samples[len(samples)-1]
+		}
+		list = append(list, stat)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Op < list[j].Op })
+	return list
+}
+
+// WriteStats writes the statistics into the writer as a human readable table.
+func WriteStats(w io.Writer, stats []*Stat) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "REQUEST\tCOUNT\tERRORS\tP50\tP90\tP99\tMAX")
+	for _, s := range stats {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%v\t%v\t%v\t%v\n", s.Op, s.Count, s.Errors, s.P50, s.P90, s.P99, s.Max)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,31 @@
+package workload
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	var results []*Result
+	for i := 1; i <= 10; i++ {
+		results = append(results, &Result{Client: "c0", Op: OpGetBalance, Latency: time.Duration(i) * time.Millisecond})
+	}
+	results = append(results, &Result{Client: "c0", Op: OpGetBalance, Err: errors.New("timeout")})
+	results = append(results, &Result{Client: "c1", Op: OpCall, Err: errOverloaded})
+
+	stats := Stats(results)
+	if len(stats) != 2 {
+		t.Fatalf("Unexpected stat number, want 2, got %d", len(stats))
+	}
+	call, balance := stats[0], stats[1]
+	if call.Op != OpCall || call.Count != 1 || call.Errors != 1 || call.Max != 0 {
+		t.Fatalf("Unexpected call stat %+v", call)
+	}
+	if balance.Count != 11 || balance.Errors != 1 {
+		t.Fatalf("Unexpected balance stat %+v", balance)
+	}
+	if balance.P50 != 5*time.Millisecond || balance.P90 != 9*time.Millisecond || balance.Max != 10*time.Millisecond {
+		t.Fatalf("Unexpected balance latencies %+v", balance)
+	}
+}
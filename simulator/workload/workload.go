@@ -0,0 +1,374 @@
+// Package workload generates the on-demand request workload on the light
+// clients and records the latency and errors of each request.
+package workload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// Op is the type of light client request.
+type Op string
+
+const (
+	OpGetBalance Op = "eth_getBalance"
+	OpGetReceipt Op = "eth_getTransactionReceipt"
+	OpCall       Op = "eth_call"
+	OpGetLogs    Op = "eth_getLogs"
+	OpSendTx     Op = "eth_sendRawTransaction"
+)
+
+// Mode is the way the requests are issued.
+type Mode int
+
+const (
+	// OpenLoop issues the requests at the target rate regardless of whether
+	// the previous requests are completed.
+	OpenLoop Mode = iota
+
+	// ClosedLoop issues the next request only after the previous one is
+	// completed, the load is decided by the number of workers.
+	ClosedLoop
+)
+
+// MaxRate is the maximum request rate of each client in the open-loop mode.
+// The requests are issued on the ticks of a ticker, which can't fire reliably
+// more often than every 100 microseconds. The ticks missed beyond that are
+// dropped silently rather than recorded as overloaded.
+const MaxRate = 10000
+
+var (
+	errOverloaded = errors.New("too many requests in flight")
+	errNoInput    = errors.New("no input for request")
+)
+
+// Config contains the settings of the workload.
+type Config struct {
+	Mode     Mode
+	Duration time.Duration // How long the workload runs
+	Clients  []int         // Indexes of the clients to run workload, nil means all
+
+	// Rate is the target number of requests per second of each client in
+	// the open-loop mode, which is at most `MaxRate`.
+	Rate float64
+
+	// MaxInFlight is the maximum number of pending requests of each client
+	// in the open-loop mode, the request exceeding the limit is recorded
+	// as failed. The default value is 0 which means 1024.
+	MaxInFlight int
+
+	// Workers is the number of concurrent workers of each client in the
+	// closed-loop mode. The default value is 0 which means 1.
+	Workers int
+
+	// ThinkTime is the pause between two requests of a closed-loop worker.
+	ThinkTime time.Duration
+
+	// Mix is the weights of the request types, e.g. {OpGetBalance: 3,
+	// OpCall: 1}. The request type without the required input is skipped.
+	Mix map[Op]int
+
+	// Seed is the seed for picking the request types and inputs.
+	Seed int64
+
+	// Inputs of the requests.
+	Accounts []common.Address       // Accounts for eth_getBalance
+	TxHashes []common.Hash          // Transactions for eth_getTransactionReceipt
+	Calls    []ethereum.CallMsg     // Messages for eth_call
+	Filters  []ethereum.FilterQuery // Filters for eth_getLogs
+
+	// Sender, ChainID and Recipient are used for sending the transfers, the
+	// sender must be funded.
+	Sender    *ecdsa.PrivateKey
+	ChainID   *big.Int
+	Recipient common.Address
+}
+
+// Result is the outcome of a single request.
+type Result struct {
+	Client  string
+	Op      Op
+	Start   time.Time
+	Latency time.Duration
+	Err     error
+}
+
+// Engine issues the configured workload on the clients.
+type Engine struct {
+	config  *Config
+	clients []*target
+	ops     []Op // Request types expanded by weight
+
+	lock    sync.Mutex
+	rand    *rand.Rand
+	results []*Result
+	nonce   *uint64 // Next nonce of the sender, nil if not yet initialized
+}
+
+// target is the client to run workload.
+type target struct {
+	name   string
+	client *ethclient.Client
+}
+
+// NewEngine creates the workload engine on the clients of the cluster.
+func NewEngine(cluster *simulator.Cluster, config *Config) (*Engine, error) {
+	clients := cluster.Clients()
+	indexes := config.Clients
+	if indexes == nil {
+		for i := range clients {
+			indexes = append(indexes, i)
+		}
+	}
+	e := &Engine{config: config, rand: rand.New(rand.NewSource(config.Seed))}
+	for _, index := range indexes {
+		if index < 0 || index >= len(clients) {
+			return nil, fmt.Errorf("invalid client index %d", index)
+		}
+		c, err := clients[index].Node().Client()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", clients[index].Name(), err)
+		}
+		e.clients = append(e.clients, &target{name: clients[index].Name(), client: ethclient.NewClient(c)})
+	}
+	// Expand the request types by weight, sorted for deterministic picking.
+	var ops []Op
+	for op := range config.Mix {
+		if e.available(op) {
+			ops = append(ops, op)
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i] < ops[j] })
+	for _, op := range ops {
+		for i := 0; i < config.Mix[op]; i++ {
+			e.ops = append(e.ops, op)
+		}
+	}
+	if len(e.ops) == 0 {
+		return nil, errors.New("no request in the mix")
+	}
+	if config.Mode == OpenLoop && (config.Rate <= 0 || config.Rate > MaxRate) {
+		return nil, errors.New("invalid request rate")
+	}
+	return e, nil
+}
+
+// available returns whether the inputs of the request type are provided.
+func (e *Engine) available(op Op) bool {
+	switch op {
+	case OpGetBalance:
+		return len(e.config.Accounts) > 0
+	case OpGetReceipt:
+		return len(e.config.TxHashes) > 0
+	case OpCall:
+		return len(e.config.Calls) > 0
+	case OpGetLogs:
+		return len(e.config.Filters) > 0
+	case OpSendTx:
+		return e.config.Sender != nil && e.config.ChainID != nil
+	}
+	return false
+}
+
+// Run issues the workload on all the clients and blocks until the configured
+// duration elapses or the context is cancelled. All the issued requests are
+// waited to be completed.
+func (e *Engine) Run(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, e.config.Duration)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, t := range e.clients {
+		wg.Add(1)
+		go func(t *target) {
+			defer wg.Done()
+			if e.config.Mode == ClosedLoop {
+				e.closedLoop(ctx, t)
+			} else {
+				e.openLoop(ctx, t)
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (e *Engine) openLoop(ctx context.Context, t *target) {
+	var (
+		wg       sync.WaitGroup
+		limit    = e.config.MaxInFlight
+		interval = time.Duration(float64(time.Second) / e.config.Rate)
+		ticker   = time.NewTicker(interval)
+	)
+	defer ticker.Stop()
+
+	if limit <= 0 {
+		limit = 1024
+	}
+	inflight := make(chan struct{}, limit)
+	for {
+		select {
+		case <-ticker.C:
+			op := e.pick()
+			select {
+			case inflight <- struct{}{}:
+			default:
+				e.record(&Result{Client: t.name, Op: op, Start: time.Now(), Err: errOverloaded})
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-inflight
+					wg.Done()
+				}()
+				e.issue(ctx, t, op)
+			}()
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+	}
+}
+
+func (e *Engine) closedLoop(ctx context.Context, t *target) {
+	workers := e.config.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				e.issue(ctx, t, e.pick())
+				if e.config.ThinkTime > 0 {
+					select {
+					case <-time.After(e.config.ThinkTime):
+					case <-ctx.Done():
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// pick randomly selects the request type by weight.
+func (e *Engine) pick() Op {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.ops[e.rand.Intn(len(e.ops))]
+}
+
+// input randomly selects an index of the request inputs.
+func (e *Engine) input(n int) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.rand.Intn(n)
+}
+
+// issue sends a single request and records the result. The request which is
+// interrupted by the end of the workload is not recorded.
+func (e *Engine) issue(ctx context.Context, t *target, op Op) {
+	start := time.Now()
+	err := e.request(ctx, t, op)
+	if ctx.Err() != nil && err != nil {
+		return
+	}
+	e.record(&Result{Client: t.name, Op: op, Start: start, Latency: time.Since(start), Err: err})
+}
+
+func (e *Engine) request(ctx context.Context, t *target, op Op) error {
+	var err error
+	switch op {
+	case OpGetBalance:
+		_, err = t.client.BalanceAt(ctx, e.config.Accounts[e.input(len(e.config.Accounts))], nil)
+	case OpGetReceipt:
+		_, err = t.client.TransactionReceipt(ctx, e.config.TxHashes[e.input(len(e.config.TxHashes))])
+	case OpCall:
+		_, err = t.client.CallContract(ctx, e.config.Calls[e.input(len(e.config.Calls))], nil)
+	case OpGetLogs:
+		_, err = t.client.FilterLogs(ctx, e.config.Filters[e.input(len(e.config.Filters))])
+	case OpSendTx:
+		err = e.sendTx(ctx, t)
+	default:
+		err = errNoInput
+	}
+	return err
+}
+
+// sendTx sends a transfer from the configured sender. The nonce is shared by
+// all the clients and tracked locally, it's dropped if the submission fails
+// so that it's re-fetched from the client next time.
+func (e *Engine) sendTx(ctx context.Context, t *target) error {
+	nonce, err := e.nextNonce(ctx, t)
+	if err != nil {
+		return err
+	}
+	tx := types.NewTransaction(nonce, e.config.Recipient, big.NewInt(1), params.TxGas, big.NewInt(params.GWei), nil)
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(e.config.ChainID), e.config.Sender)
+	if err == nil {
+		err = t.client.SendTransaction(ctx, signed)
+	}
+	if err != nil {
+		e.lock.Lock()
+		e.nonce = nil
+		e.lock.Unlock()
+	}
+	return err
+}
+
+// nextNonce allocates the nonce for the next transfer. The nonce is fetched
+// from the client without holding the lock if it's not yet initialized.
+func (e *Engine) nextNonce(ctx context.Context, t *target) (uint64, error) {
+	e.lock.Lock()
+	if e.nonce == nil {
+		e.lock.Unlock()
+		nonce, err := t.client.PendingNonceAt(ctx, crypto.PubkeyToAddress(e.config.Sender.PublicKey))
+		if err != nil {
+			return 0, err
+		}
+		e.lock.Lock()
+		// The nonce may be initialized by the other sender in the meantime
+		if e.nonce == nil {
+			e.nonce = &nonce
+		}
+	}
+	nonce := *e.nonce
+	*e.nonce++
+	e.lock.Unlock()
+	return nonce, nil
+}
+
+func (e *Engine) record(r *Result) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.results = append(e.results, r)
+}
+
+// Results returns all the recorded request results.
+func (e *Engine) Results() []*Result {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return append([]*Result(nil), e.results...)
+}
@@ -0,0 +1,104 @@
+package workload
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rjl493456442/les-simulator/simulator"
+)
+
+// testEthAPI is the minimal eth namespace for accepting the transfers, the
+// pending nonce is increased by each accepted transaction.
+type testEthAPI struct {
+	lock    sync.Mutex
+	nonce   uint64   // Pending nonce of the sender
+	reject  bool     // Whether to reject the next transaction
+	fetches int      // Number of the pending nonce retrievals
+	nonces  []uint64 // Nonces of the accepted transactions
+}
+
+func (api *testEthAPI) GetTransactionCount(account common.Address, block string) hexutil.Uint64 {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	api.fetches++
+	return hexutil.Uint64(api.nonce)
+}
+
+func (api *testEthAPI) SendRawTransaction(blob hexutil.Bytes) (common.Hash, error) {
+	api.lock.Lock()
+	defer api.lock.Unlock()
+
+	tx := new(types.Transaction)
+	if err := rlp.DecodeBytes(blob, tx); err != nil {
+		return common.Hash{}, err
+	}
+	if api.reject {
+		api.reject = false
+		return common.Hash{}, errors.New("rejected")
+	}
+	if tx.Nonce() != api.nonce {
+		return common.Hash{}, errors.New("nonce gap")
+	}
+	api.nonce++
+	api.nonces = append(api.nonces, tx.Nonce())
+	return tx.Hash(), nil
+}
+
+func TestSendTxNonce(t *testing.T) {
+	api := &testEthAPI{nonce: 5}
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", api); err != nil {
+		t.Fatalf("Failed to register API: %v", err)
+	}
+	defer server.Stop()
+
+	key, _ := crypto.GenerateKey()
+	e := &Engine{config: &Config{Sender: key, ChainID: big.NewInt(1337), Recipient: common.Address{0x01}}}
+	target := &target{name: "c0", client: ethclient.NewClient(rpc.DialInProc(server))}
+
+	for i := 0; i < 2; i++ {
+		if err := e.sendTx(context.Background(), target); err != nil {
+			t.Fatalf("Failed to send transaction: %v", err)
+		}
+	}
+	// The nonce should be re-fetched after the failure, no gap is left
+	api.reject = true
+	if err := e.sendTx(context.Background(), target); err == nil {
+		t.Fatal("Expected rejected transaction")
+	}
+	for i := 0; i < 2; i++ {
+		if err := e.sendTx(context.Background(), target); err != nil {
+			t.Fatalf("Failed to send transaction after failure: %v", err)
+		}
+	}
+	if want := []uint64{5, 6, 7, 8}; !reflect.DeepEqual(api.nonces, want) {
+		t.Fatalf("Unexpected nonces, want %v, got %v", want, api.nonces)
+	}
+	if api.fetches != 2 {
+		t.Fatalf("Unexpected nonce retrievals, want 2, got %d", api.fetches)
+	}
+}
+
+func TestInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, MaxRate + 1} {
+		_, err := NewEngine(new(simulator.Cluster), &Config{Mode: OpenLoop, Rate: rate, Mix: map[Op]int{OpGetBalance: 1}, Accounts: []common.Address{{0x01}}})
+		if err == nil {
+			t.Fatalf("Rate %v should be rejected", rate)
+		}
+	}
+	if _, err := NewEngine(new(simulator.Cluster), &Config{Mode: OpenLoop, Rate: MaxRate, Mix: map[Op]int{OpGetBalance: 1}, Accounts: []common.Address{{0x01}}}); err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+}